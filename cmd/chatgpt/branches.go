@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openai-workflow/workflow/internal/workflow"
+)
+
+// editQuestion replaces an earlier question on a new branch and returns that
+// branch, which ends with the edited question awaiting an answer.
+func editQuestion(env *workflow.Env, id, content string) ([]workflow.Message, error) {
+	var branch []workflow.Message
+	err := workflow.UpdateChatTree(env.ChatFile, func(tree *workflow.ChatTree) error {
+		if err := tree.Edit(id, content); err != nil {
+			return err
+		}
+		branch = tree.Branch()
+		return nil
+	})
+	return branch, err
+}
+
+// listQuestions offers the questions of the active branch for editing. The
+// selected question's text is passed on so it can be amended.
+func listQuestions(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	chat, err := workflow.ReadChat(env.ChatFile)
+	if err != nil {
+		return err
+	}
+
+	var items []scriptFilterItem
+	for i := len(chat) - 1; i >= 0; i-- {
+		if chat[i].Role != "user" {
+			continue
+		}
+		items = append(items, scriptFilterItem{
+			UID:       chat[i].ID,
+			Title:     singleLine(chat[i].Content),
+			Subtitle:  "Edit this question and branch the conversation from here",
+			Arg:       chat[i].Content,
+			Variables: map[string]string{"edit_message_id": chat[i].ID},
+		})
+	}
+	if len(items) == 0 {
+		items = append(items, scriptFilterItem{
+			Title: "No Questions to Edit",
+			Valid: boolPtr(false),
+		})
+	}
+	return emitItems(items)
+}
+
+func listBranches(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	tree, err := workflow.ReadChatTree(env.ChatFile)
+	if err != nil {
+		return err
+	}
+
+	branches := tree.Branches()
+	items := make([]scriptFilterItem, 0, len(branches))
+	for i := len(branches) - 1; i >= 0; i-- {
+		branch := branches[i]
+		var subtitle string
+		switch {
+		case branch.Active:
+			subtitle = fmt.Sprintf("%d messages · current branch", branch.Messages)
+		case branch.DivergesAfter == 0:
+			subtitle = fmt.Sprintf("%d messages · starts from a different first question", branch.Messages)
+		default:
+			subtitle = fmt.Sprintf("%d messages · branches off after message %d", branch.Messages, branch.DivergesAfter)
+		}
+		items = append(items, scriptFilterItem{
+			UID:      branch.Leaf,
+			Title:    singleLine(branch.LastQuestion),
+			Subtitle: subtitle,
+			Arg:      branch.Leaf,
+		})
+	}
+	if len(items) == 0 {
+		items = append(items, scriptFilterItem{
+			Title: "No Branches Yet",
+			Valid: boolPtr(false),
+		})
+	}
+	return emitItems(items)
+}
+
+func switchBranch(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: --switch-branch <id>")
+	}
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	return workflow.UpdateChatTree(env.ChatFile, func(tree *workflow.ChatTree) error {
+		return tree.Switch(args[0])
+	})
+}
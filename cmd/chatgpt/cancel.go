@@ -0,0 +1,46 @@
+package main
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/openai-workflow/workflow/internal/workflow"
+)
+
+// How long --cancel waits for the stream process to save what it has.
+const cancelWait = 2 * time.Second
+
+// cancelStream stops the answer being streamed. The stream process finishes
+// it as cancelled so the next poll keeps the partial answer; if the process
+// is already gone, the stream file is marked cancelled here instead.
+func cancelStream(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	if !workflow.StreamFileExists(env.StreamFile) {
+		workflow.RemoveFiles(env.PIDFile)
+		return nil
+	}
+
+	if err := workflow.SignalStreamProcess(env.PIDFile, syscall.SIGTERM); err == nil {
+		deadline := time.Now().Add(cancelWait)
+		for time.Now().Before(deadline) {
+			if state, err := workflow.ReadStreamState(env.StreamFile); err != nil || state.FinishReason != "" || state.Error != "" {
+				return nil
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	state, err := workflow.ReadStreamState(env.StreamFile)
+	if err != nil {
+		return err
+	}
+	if state.FinishReason != "" || state.Error != "" {
+		return nil
+	}
+	state.FinishReason = workflow.FinishCancelled
+	state.Status = ""
+	return workflow.WriteStreamState(env.StreamFile, state)
+}
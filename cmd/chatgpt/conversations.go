@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openai-workflow/workflow/internal/workflow"
+)
+
+// newConversation registers a conversation and prints its ID, ready to be
+// passed on as conversation_id.
+func newConversation(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	conv, err := workflow.NewConversation(env.ConversationsFile, strings.Join(args, " "), time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println(conv.ID)
+	return nil
+}
+
+func listConversations(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	conversations, err := workflow.ReadConversations(env.ConversationsFile)
+	if err != nil {
+		return err
+	}
+
+	var items []scriptFilterItem
+	for _, conv := range conversations {
+		files, err := workflow.ConversationPaths(env.WorkflowDataDir, env.WorkflowCacheDir, conv.ID)
+		if err != nil {
+			continue
+		}
+		title := conv.Title
+		if title == "" {
+			title = "Untitled conversation"
+		}
+		subtitle := "Updated " + conv.Updated.Local().Format("2006-01-02 15:04")
+		if workflow.StreamFileExists(files.Stream) {
+			subtitle += " · Answering…"
+		}
+		items = append(items, scriptFilterItem{
+			UID:       conv.ID,
+			Title:     title,
+			Subtitle:  subtitle,
+			Arg:       conv.ID,
+			Match:     title,
+			Variables: map[string]string{"conversation_id": conv.ID},
+		})
+	}
+	if len(items) == 0 {
+		items = append(items, scriptFilterItem{
+			Title:    "No Conversations Found",
+			Subtitle: "Conversations are registered when you ask a question",
+			Valid:    boolPtr(false),
+		})
+	}
+	return emitItems(items)
+}
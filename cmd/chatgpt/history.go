@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/openai/openai-go"
+
+	"github.com/openai-workflow/workflow/internal/workflow"
+)
+
+const (
+	searchResultLimit = 20
+	// Semantic search embeds pending archives for at most this long per
+	// invocation and picks up the rest next time.
+	embeddingTimeBudget = 15 * time.Second
+)
+
+// archiveChat stores the current conversation in the archive and starts a
+// fresh one.
+func archiveChat(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	id, err := workflow.ArchiveChat(env.ChatFile, workflow.ArchiveDir(env.WorkflowDataDir), time.Now())
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+	if _, err := workflow.RefreshSearchIndex(workflow.SearchIndexPath(env.WorkflowDataDir), workflow.ArchiveDir(env.WorkflowDataDir)); err != nil {
+		fmt.Fprintln(os.Stderr, "search index error:", err)
+	}
+	fmt.Println(id)
+	return nil
+}
+
+func restoreChat(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: --restore <id>")
+	}
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	id, err := workflow.ArchiveID(args[0])
+	if err != nil {
+		return err
+	}
+	return workflow.RestoreArchive(env.ChatFile, workflow.ArchiveDir(env.WorkflowDataDir), id, time.Now())
+}
+
+func listHistory(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	archives, err := workflow.ListArchives(workflow.ArchiveDir(env.WorkflowDataDir))
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return emitItems([]scriptFilterItem{{
+			Title:    "No Chat Histories Found",
+			Subtitle: "Archives are created when starting new conversations",
+			Valid:    boolPtr(false),
+		}})
+	}
+
+	items := make([]scriptFilterItem, 0, len(archives))
+	for _, archive := range archives {
+		first := singleLine(archive.FirstQuestion)
+		last := singleLine(archive.LastQuestion)
+		items = append(items, scriptFilterItem{
+			UID:      archive.ID,
+			Type:     "file",
+			Title:    first,
+			Subtitle: fmt.Sprintf("%s · %s · %d messages", last, archive.Created.Format("2006-01-02 15:04"), archive.Messages),
+			Match:    first + " " + last,
+			Arg:      archive.Path,
+		})
+	}
+	return emitItems(items)
+}
+
+// searchHistory ranks archived chats by full-text relevance to the query and
+// shows the best matching passage of each.
+func searchHistory(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	query := strings.Join(args, " ")
+	archiveDir := workflow.ArchiveDir(env.WorkflowDataDir)
+	idx, err := workflow.RefreshSearchIndex(workflow.SearchIndexPath(env.WorkflowDataDir), archiveDir)
+	if err != nil {
+		return err
+	}
+
+	var items []scriptFilterItem
+	for _, hit := range idx.Search(query, searchResultLimit) {
+		path := workflow.ArchivePath(archiveDir, hit.ID)
+		msgs, err := workflow.ReadChat(path)
+		if err != nil || hit.Msg >= len(msgs) {
+			continue
+		}
+		items = append(items, scriptFilterItem{
+			UID:      hit.ID,
+			Type:     "file",
+			Title:    singleLine(hit.Title),
+			Subtitle: workflow.Snippet(msgs[hit.Msg].Content, query),
+			Arg:      path,
+		})
+	}
+	if len(items) == 0 {
+		items = append(items, scriptFilterItem{
+			Title:    "No Matching Chats",
+			Subtitle: "Nothing in the chat archive matches “" + query + "”",
+			Valid:    boolPtr(false),
+		})
+	}
+	return emitItems(items)
+}
+
+func semanticSearch(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return err
+	}
+	query := strings.TrimSpace(strings.Join(args, " "))
+	if query == "" {
+		return emitItems([]scriptFilterItem{{Title: "Describe what you are looking for", Valid: boolPtr(false)}})
+	}
+	client, err := workflow.NewClient(workflow.ClientOptions{
+		APIKey:         env.APIKey,
+		OrgID:          env.OrgID,
+		BaseURL:        workflow.NormalizeBaseURL(env.ChatAPIEndpoint, "https://api.openai.com/v1", "/chat/completions", "/embeddings"),
+		ConnectTimeout: env.ConnectTimeout,
+		Azure:          env.Azure,
+	})
+	if err != nil {
+		return err
+	}
+	embed := func(ctx context.Context, texts []string) ([][]float32, error) {
+		var resp *openai.CreateEmbeddingResponse
+		err := workflow.Retry(ctx, env.Retry, nil, func() (err error) {
+			resp, err = client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+				Model: openai.EmbeddingModel(env.EmbeddingModel),
+				Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		vectors := make([][]float32, len(texts))
+		for _, item := range resp.Data {
+			if int(item.Index) >= len(vectors) {
+				continue
+			}
+			vector := make([]float32, len(item.Embedding))
+			for i, v := range item.Embedding {
+				vector[i] = float32(v)
+			}
+			vectors[item.Index] = vector
+		}
+		return vectors, nil
+	}
+
+	ctx := context.Background()
+	indexPath := workflow.EmbeddingIndexPath(env.WorkflowDataDir)
+	archiveDir := workflow.ArchiveDir(env.WorkflowDataDir)
+	idx, progress, err := workflow.UpdateEmbeddingIndex(ctx, indexPath, archiveDir, env.EmbeddingModel, embed, time.Now().Add(embeddingTimeBudget))
+	if err != nil {
+		return err
+	}
+	queryVectors, err := embed(ctx, []string{query})
+	if err != nil {
+		return err
+	}
+
+	var items []scriptFilterItem
+	if progress.Indexed < progress.Total {
+		items = append(items, scriptFilterItem{
+			Title:    fmt.Sprintf("Indexing chat history… %d of %d chats", progress.Indexed, progress.Total),
+			Subtitle: "Results cover the chats indexed so far; search again to continue",
+			Valid:    boolPtr(false),
+		})
+	}
+	for _, hit := range idx.Nearest(queryVectors[0], searchResultLimit) {
+		path := workflow.ArchivePath(archiveDir, hit.ID)
+		subtitle := fmt.Sprintf("%.0f%% match", hit.Score*100)
+		if msgs, err := workflow.ReadChat(path); err == nil && hit.Msg < len(msgs) {
+			subtitle += " · " + singleLine(msgs[hit.Msg].Content)
+		}
+		items = append(items, scriptFilterItem{
+			UID:      hit.ID,
+			Type:     "file",
+			Title:    singleLine(hit.Title),
+			Subtitle: subtitle,
+			Arg:      path,
+		})
+	}
+	if len(items) == 0 {
+		items = append(items, scriptFilterItem{
+			Title: "No Chat Histories Found",
+			Valid: boolPtr(false),
+		})
+	}
+	return emitItems(items)
+}
+
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -5,16 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	openai "github.com/openai/openai-go"
-
 	"github.com/openai-workflow/workflow/internal/workflow"
 )
 
@@ -26,18 +25,56 @@ type alfredResponse struct {
 	Footer    string            `json:"footer,omitempty"`
 }
 
+type scriptFilterItem struct {
+	UID       string            `json:"uid,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	Title     string            `json:"title"`
+	Subtitle  string            `json:"subtitle,omitempty"`
+	Arg       string            `json:"arg,omitempty"`
+	Match     string            `json:"match,omitempty"`
+	Valid     *bool             `json:"valid,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+type scriptFilterResponse struct {
+	Items []scriptFilterItem `json:"items"`
+}
+
+var commands = map[string]func(args []string) error{
+	"--new-conversation":   newConversation,
+	"--list-conversations": listConversations,
+	"--archive":            archiveChat,
+	"--list-history":       listHistory,
+	"--restore":            restoreChat,
+	"--search":             searchHistory,
+	"--semantic-search":    semanticSearch,
+	"--list-questions":     listQuestions,
+	"--list-branches":      listBranches,
+	"--switch-branch":      switchBranch,
+	"--usage":              showUsage,
+	"--cancel":             cancelStream,
+	"--dump-chat":          dumpChat,
+}
+
 const (
 	streamModeEnv = "GOCHAT_MODE"
 	streamModeRun = "stream"
+	// Extra time the poller allows past the stream process's own timeouts
+	// before treating it as wedged.
+	streamGrace = 30 * time.Second
+	// Sent after a cut-off answer to have the model carry on from it.
+	continuePrompt = "Continue your previous answer exactly where it stopped. Do not repeat anything or start over."
 )
 
 func main() {
-	if len(os.Args) > 2 && os.Args[1] == "--dump-chat" {
-		if err := dumpChat(os.Args[2]); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
 		}
-		return
 	}
 
 	if os.Getenv(streamModeEnv) == streamModeRun {
@@ -69,8 +106,9 @@ func runPrimary() error {
 	if err := workflow.EnsureHelperBinary(env.WorkflowDataDir); err != nil {
 		return respondError(err)
 	}
-	if env.APIKey == "" {
-		return respondError(errors.New("OpenAI API key missing"))
+	routed, provider, _ := env.ChatModel()
+	if err := routed.CheckAPIKey(provider); err != nil {
+		return respondError(err)
 	}
 
 	if err := workflow.EnsureChatFile(env.ChatFile); err != nil {
@@ -90,6 +128,14 @@ func runPrimary() error {
 		return respondStream(env, streamMarker)
 	}
 
+	// A stream process without a stream file was left behind by a chat that
+	// has since been archived or reset. SIGKILL stops it without the final
+	// write a cancelled stream makes, which would recreate the file.
+	if !workflow.StreamFileExists(env.StreamFile) && workflow.StreamFileExists(env.PIDFile) {
+		workflow.SignalStreamProcess(env.PIDFile, syscall.SIGKILL)
+		workflow.RemoveFiles(env.PIDFile)
+	}
+
 	// Resume stream if files linger
 	if workflow.StreamFileExists(env.StreamFile) {
 		resp := alfredResponse{
@@ -101,12 +147,21 @@ func runPrimary() error {
 		}
 		chat, err := workflow.ReadChat(env.ChatFile)
 		if err == nil {
-			resp.Response = workflow.MarkdownChat(chat, true)
+			resp.Response = chatMarkdown(env, pendingChat(chat), true)
 			resp.Behaviour = map[string]string{"scroll": "end"}
 		}
 		return emit(resp)
 	}
 
+	switch os.Getenv("chat_action") {
+	case "regenerate":
+		return regenerateAnswer(env)
+	case "next_answer":
+		return switchAnswer(env, 1)
+	case "previous_answer":
+		return switchAnswer(env, -1)
+	}
+
 	chat, err := workflow.ReadChat(env.ChatFile)
 	if err != nil {
 		return respondError(err)
@@ -114,16 +169,39 @@ func runPrimary() error {
 
 	if typedQuery == "" {
 		resp := alfredResponse{
-			Response:  workflow.MarkdownChat(chat, false),
+			Response:  chatMarkdown(env, chat, false),
 			Behaviour: map[string]string{"scroll": "end"},
 		}
+		if editID := os.Getenv("edit_message_id"); editID != "" {
+			for _, m := range chat {
+				if m.ID == editID {
+					resp.Footer = "Editing “" + singleLine(m.Content) + "”: the next question replaces it on a new branch"
+				}
+			}
+		}
 		return emit(resp)
 	}
 
-	appendMsg := workflow.Message{Role: "user", Content: typedQuery}
-	chat = append(chat, appendMsg)
-	if err := workflow.WriteChat(env.ChatFile, chat); err != nil {
-		return respondError(err)
+	if err := checkChatBudget(env, append(chat, workflow.Message{Role: "user", Content: typedQuery})); err != nil {
+		return respondRefused(env, chat, err)
+	}
+
+	if editID := os.Getenv("edit_message_id"); editID != "" {
+		chat, err = editQuestion(env, editID, typedQuery)
+		if err != nil {
+			return respondError(err)
+		}
+	} else {
+		appendMsg := workflow.Message{Role: "user", Content: typedQuery, Created: time.Now().Unix()}
+		chat, err = workflow.UpdateChat(env.ChatFile, func(chat []workflow.Message) ([]workflow.Message, error) {
+			return append(chat, appendMsg), nil
+		})
+		if err != nil {
+			return respondError(err)
+		}
+	}
+	if err := workflow.TouchConversation(env.ConversationsFile, env.ConversationID, typedQuery, time.Now()); err != nil {
+		fmt.Fprintln(os.Stderr, "conversation registry error:", err)
 	}
 
 	if err := workflow.Touch(env.StreamFile); err != nil {
@@ -134,17 +212,126 @@ func runPrimary() error {
 		return respondError(err)
 	}
 
+	resp := alfredResponse{
+		Rerun: 0.1,
+		Variables: map[string]string{
+			"streaming_now":   "1",
+			"stream_marker":   "1",
+			"edit_message_id": "",
+		},
+		Response: chatMarkdown(env, chat, true),
+	}
+	return emit(resp)
+}
+
+// regenerateAnswer streams a new answer to the last question. The current
+// answer stays in the chat until the new one is complete and then becomes one
+// of its variants.
+func regenerateAnswer(env *workflow.Env) error {
+	chat, err := workflow.ReadChat(env.ChatFile)
+	if err != nil {
+		return respondError(err)
+	}
+	if len(chat) == 0 {
+		return emit(alfredResponse{
+			Response:  "Nothing to regenerate yet. Ask a question first.",
+			Variables: map[string]string{"chat_action": ""},
+		})
+	}
+	if err := checkChatBudget(env, pendingChat(chat)); err != nil {
+		return respondRefused(env, chat, err)
+	}
+
+	if err := workflow.Touch(env.StreamFile); err != nil {
+		return respondError(err)
+	}
+	if err := startBackgroundStream(env); err != nil {
+		return respondError(err)
+	}
+
 	resp := alfredResponse{
 		Rerun: 0.1,
 		Variables: map[string]string{
 			"streaming_now": "1",
 			"stream_marker": "1",
+			"chat_action":   "",
 		},
-		Response: workflow.MarkdownChat(chat, true),
+		Response: chatMarkdown(env, pendingChat(chat), true),
 	}
 	return emit(resp)
 }
 
+func switchAnswer(env *workflow.Env, step int) error {
+	resp := alfredResponse{
+		Variables: map[string]string{"chat_action": ""},
+		Behaviour: map[string]string{"scroll": "end"},
+	}
+	resp.Footer = "There is only one answer to this question"
+	chat, err := workflow.UpdateChat(env.ChatFile, func(chat []workflow.Message) ([]workflow.Message, error) {
+		if i := workflow.LastAssistant(chat); i >= 0 && chat[i].CycleVariant(step) {
+			resp.Footer = answerFooter(chat[i])
+		}
+		return chat, nil
+	})
+	if err != nil {
+		return respondError(err)
+	}
+	resp.Response = chatMarkdown(env, chat, false)
+	return emit(resp)
+}
+
+// checkChatBudget estimates the cost of answering the last question of chat
+// and checks it against the chat budget.
+func checkChatBudget(env *workflow.Env, chat []workflow.Message) error {
+	_, _, model := env.ChatModel()
+	return checkRequestBudget(env, model, env.SystemPrompt, trimChat(env, model, chat))
+}
+
+// checkRequestBudget estimates the cost of sending messages to model after
+// system and checks it against the chat budget.
+func checkRequestBudget(env *workflow.Env, model, system string, messages []workflow.Message) error {
+	if env.ChatBudget.Daily <= 0 && env.ChatBudget.Monthly <= 0 {
+		// Counting the tokens is not free, and there is nothing to check.
+		return nil
+	}
+	enc := workflow.EncodingForModel(model)
+	return workflow.CheckBudget(env, env.ChatBudget, workflow.UsageRecord{
+		Kind:             workflow.UsageChat,
+		Model:            model,
+		PromptTokens:     int64(workflow.ContextTokens(enc, system, messages)),
+		CompletionTokens: workflow.EstimatedReplyTokens,
+	})
+}
+
+// respondRefused shows the chat as it was with the reason the request was not
+// sent below it.
+func respondRefused(env *workflow.Env, chat []workflow.Message, err error) error {
+	markdown := chatMarkdown(env, chat, false)
+	if markdown != "" {
+		markdown += "\n\n"
+	}
+	return emit(alfredResponse{
+		Response:  markdown + "**" + err.Error() + "**",
+		Variables: map[string]string{"chat_action": ""},
+		Behaviour: map[string]string{"scroll": "end"},
+	})
+}
+
+// pendingChat hides an answer that is being regenerated.
+func pendingChat(chat []workflow.Message) []workflow.Message {
+	if n := len(chat); n > 0 && chat[n-1].Role == "assistant" {
+		return chat[:n-1]
+	}
+	return chat
+}
+
+func answerFooter(msg workflow.Message) string {
+	if len(msg.Variants) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("Answer %d of %d", msg.Selected+1, len(msg.Variants))
+}
+
 func startBackgroundStream(env *workflow.Env) error {
 	executable, err := os.Executable()
 	if err != nil {
@@ -152,8 +339,9 @@ func startBackgroundStream(env *workflow.Env) error {
 	}
 	cmd := exec.Command(executable, "--stream")
 	cmd.Env = append(os.Environ(), streamModeEnv+"="+streamModeRun)
-	cmd.Stdout = io.Discard
-	cmd.Stderr = io.Discard
+	// Stdout and Stderr stay nil, which is the null device. A pipe would close
+	// when this process exits, and the stream process's next log line would
+	// then kill it with SIGPIPE.
 	if runtime.GOOS != "windows" {
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	}
@@ -175,176 +363,716 @@ func runStreamProcess() error {
 	if err := workflow.EnsureHelperBinary(env.WorkflowDataDir); err != nil {
 		return err
 	}
-	if env.APIKey == "" {
-		return workflow.WriteStreamState(env.StreamFile, workflow.StreamState{Error: "Missing OpenAI API key"})
-	}
 
-	if err := runChatStream(env); err != nil {
-		workflow.WriteStreamState(env.StreamFile, workflow.StreamState{Error: err.Error()})
+	// --cancel sends SIGTERM; the answer so far is then saved as cancelled.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	server := workflow.ServeStream(env.StreamFile, env.SocketFile)
+	defer server.Close()
+
+	if err := runChatStream(ctx, env, server); err != nil {
+		classified := workflow.ClassifyError(err)
+		server.Finish(workflow.StreamState{Error: classified.Detail, ErrorKind: classified.Kind})
 		return err
 	}
 	return nil
 }
 
-func runChatStream(env *workflow.Env) error {
-	client, err := workflow.NewClient(workflow.ClientOptions{
-		APIKey:  env.APIKey,
-		OrgID:   env.OrgID,
-		BaseURL: workflow.NormalizeBaseURL(env.ChatAPIEndpoint, "https://api.openai.com/v1", "/chat/completions"),
-	})
+func runChatStream(ctx context.Context, env *workflow.Env, server *workflow.StreamServer) error {
+	server.Publish(workflow.StreamState{})
+
+	chat, err := workflow.ReadChat(env.ChatFile)
 	if err != nil {
 		return err
 	}
 
-	if err := workflow.WriteStreamState(env.StreamFile, workflow.StreamState{}); err != nil {
-		return err
+	model := workflow.ResolveChatModel(env.GPTModel, env.ChatModelOverride)
+	if model == "" {
+		return errors.New("gpt_model not configured")
 	}
 
-	chat, err := workflow.ReadChat(env.ChatFile)
+	// A trailing answer means it is being regenerated from the same context.
+	chat = pendingChat(chat)
+
+	status := func(status string) {
+		server.Publish(workflow.StreamState{Status: status})
+	}
+	// The models in model_fallbacks are tried in turn while the ones before
+	// them are failing or send nothing in time.
+	models := append([]string{model}, env.Fallbacks...)
+	var attempt *chatAttempt
+	for i, candidate := range models {
+		if i > 0 {
+			// The first model was checked before the chat started; each
+			// fallback is another request against the chat budget.
+			_, _, name := env.Route(candidate)
+			if err = checkRequestBudget(env, name, env.SystemPrompt, trimChat(env, name, chat)); err != nil {
+				break
+			}
+			status(fmt.Sprintf("%s is unavailable, trying %s…", models[i-1], candidate))
+		}
+		attempt, err = startChat(ctx, env, status, chat, candidate)
+		if err == nil || ctx.Err() != nil || !workflow.ShouldFallBack(err) {
+			break
+		}
+	}
 	if err != nil {
+		if ctx.Err() != nil {
+			return server.Finish(workflow.StreamState{FinishReason: workflow.FinishCancelled})
+		}
+		if errors.Is(err, workflow.ErrFirstTokenTimeout) {
+			return server.Finish(workflow.StreamState{FinishReason: workflow.FinishStalled})
+		}
 		return err
 	}
+	provider, name, enc := attempt.provider, attempt.name, attempt.enc
+	sent, trimmed, summary, previousID := attempt.sent, attempt.trimmed, attempt.summary, attempt.previousID
+	stream, watchdog, started := attempt.stream, attempt.watchdog, attempt.started
 
-	trimmed := workflow.TrimContext(chat, env.MaxContext)
-	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(trimmed)+1)
-	if env.SystemPrompt != "" {
-		messages = append(messages, openai.SystemMessage(env.SystemPrompt))
+	// With auto_continue_rounds set, an answer cut off by the token limit or a
+	// stall is carried on by further requests and stitched into one answer.
+	answer := strings.Builder{}
+	var reasoning *strings.Builder
+	if env.ShowReasoning {
+		reasoning = &strings.Builder{}
 	}
-	for _, m := range trimmed {
-		switch m.Role {
-		case "user":
-			messages = append(messages, openai.UserMessage(m.Content))
-		case "assistant":
-			messages = append(messages, openai.AssistantMessage(m.Content))
+	var promptTokens, completionTokens int64
+	var finishReason, answeredBy, fallbackFor, responseID string
+	if attempt.model != model {
+		fallbackFor = model
+	}
+	for round := 0; ; round++ {
+		before := answer.String()
+		result, err := receiveChatStream(stream, watchdog, started, server, &answer, reasoning)
+
+		usage := workflow.UsageRecord{
+			Time:             time.Now(),
+			Kind:             workflow.UsageChat,
+			Model:            name,
+			PromptTokens:     result.PromptTokens,
+			CompletionTokens: result.CompletionTokens,
+		}
+		if result.Model != "" {
+			usage.Model = result.Model
+		}
+		if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+			// Endpoints that ignore include_usage, and streams that broke off,
+			// report nothing; count locally so the ledger is never silent.
+			usage.PromptTokens = int64(workflow.ContextTokens(enc, env.SystemPrompt+summary, trimmed) + workflow.CountTokens(enc, before))
+			usage.CompletionTokens = int64(workflow.CountTokens(enc, strings.TrimPrefix(answer.String(), before)))
+			usage.Estimated = true
+		}
+		recordUsage(env, usage)
+		promptTokens += result.PromptTokens
+		completionTokens += result.CompletionTokens
+		answeredBy = usage.Model
+		responseID = result.ResponseID
+
+		finishReason = result.FinishReason
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				finishReason = workflow.FinishCancelled
+			case errors.Is(err, workflow.ErrIdleTimeout), errors.Is(err, workflow.ErrFirstTokenTimeout):
+				finishReason = workflow.FinishStalled
+			case round > 0:
+				// Keep what the earlier rounds produced.
+				finishReason = workflow.FinishStalled
+			default:
+				return err
+			}
+		}
+
+		if round >= env.ContinueRounds || answer.Len() == 0 || (finishReason != "length" && finishReason != workflow.FinishStalled) {
+			break
+		}
+		partial := answer.String()
+		continuing := func(status string) {
+			state := workflow.StreamState{Content: partial, Status: status}
+			if reasoning != nil {
+				state.Reasoning = reasoning.String()
+			}
+			server.Publish(state)
+		}
+		messages := append(append([]workflow.Message{}, trimmed...),
+			workflow.Message{Role: "assistant", Content: partial},
+			workflow.Message{Role: "user", Content: continuePrompt})
+		if checkRequestBudget(env, name, env.SystemPrompt+summary, messages) != nil {
+			// Another round would go over the chat budget, so the answer
+			// stays cut off where it is.
+			break
+		}
+		continuing(fmt.Sprintf("Continuing the answer (%d of %d)…", round+1, env.ContinueRounds))
+		stream, watchdog, started, err = openChatStream(ctx, provider, env, continuing, attempt.model, chatRequest(env, name, summary, messages, previousID))
+		if err != nil {
+			// The answer so far still stands, cut off as it was.
+			if ctx.Err() != nil {
+				finishReason = workflow.FinishCancelled
+			}
+			break
 		}
 	}
 
-	model := workflow.ResolveChatModel(env.GPTModel, env.ChatModelOverride)
-	if model == "" {
-		return errors.New("gpt_model not configured")
+	final := workflow.StreamState{
+		Content:          answer.String(),
+		FinishReason:     finishReason,
+		Model:            answeredBy,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Dropped:          droppedMessages(sent, trimmed),
+		FallbackFor:      fallbackFor,
+		SummaryError:     attempt.summaryError,
+		ResponseID:       responseID,
+	}
+	if reasoning != nil {
+		final.Reasoning = strings.TrimSpace(reasoning.String())
 	}
+	return server.Finish(final)
+}
 
-	ctx := context.Background()
-	stream := client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-		Model:    model,
-		Messages: messages,
-	})
+// chatAttempt is a chat sent to one model, with its stream opened.
+type chatAttempt struct {
+	provider workflow.ChatProvider
+	name     string
+	enc      workflow.Encoding
+	model    string
+	sent     []workflow.Message
+	trimmed  []workflow.Message
+	summary  string
+	// summaryError says why the turns left out could not be summarised.
+	summaryError string
+	// previousID is the stored answer the chat continues from, when the
+	// provider keeps the conversation and only the messages after it are sent.
+	previousID string
+	stream     workflow.ChatStream
+	watchdog   *workflow.Watchdog
+	started    bool
+}
 
-	acc := openai.ChatCompletionAccumulator{}
-	builder := strings.Builder{}
-
-	for stream.Next() {
-		chunk := stream.Current()
-		acc.AddChunk(chunk)
-		if len(chunk.Choices) > 0 {
-			delta := chunk.Choices[0].Delta.Content
-			if delta != "" {
-				builder.WriteString(delta)
-				workflow.WriteStreamState(env.StreamFile, workflow.StreamState{Content: builder.String()})
-			}
+// startChat sends chat to model and waits for the answer to start. A context
+// the model cannot take is retried with less history for as long as there is
+// older history left to drop.
+func startChat(ctx context.Context, env *workflow.Env, status func(string), chat []workflow.Message, model string) (*chatAttempt, error) {
+	provider, name, err := workflow.NewChatProvider(env, model)
+	if err != nil {
+		return nil, err
+	}
+	attempt := &chatAttempt{provider: provider, name: name, model: model, enc: workflow.EncodingForModel(name)}
+	attempt.sent = trimChat(env, name, chat)
+	if workflow.KeepsConversation(provider) {
+		if id, rest := workflow.ResumePoint(chat); id != "" {
+			attempt.previousID, attempt.sent = id, rest
 		}
 	}
+	attempt.trimmed = attempt.sent
+	for {
+		dropped := len(chat) - len(attempt.trimmed)
+		if attempt.previousID != "" {
+			// The server still holds the messages that were not sent.
+			dropped = 0
+		}
+		var summaryErr error
+		attempt.summary, summaryErr = updateSummary(ctx, env, status, chat, dropped, model)
+		attempt.summaryError = ""
+		if summaryErr != nil {
+			fmt.Fprintln(os.Stderr, "summary error:", summaryErr)
+			attempt.summaryError = workflow.ClassifyError(summaryErr).Summary()
+		}
+		if env.Thinks(model) {
+			status("Thinking…")
+		}
+		attempt.stream, attempt.watchdog, attempt.started, err = openChatStream(ctx, provider, env, status, model, chatRequest(env, name, attempt.summary, attempt.trimmed, attempt.previousID))
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt.previousID != "" && workflow.IsMissingPreviousResponse(err) {
+			// The stored conversation has expired; send the history instead.
+			attempt.previousID = ""
+			attempt.sent = trimChat(env, name, chat)
+			attempt.trimmed = attempt.sent
+			continue
+		}
+		shorter := workflow.ShrinkContext(attempt.trimmed, attempt.enc)
+		if ctx.Err() != nil || workflow.ClassifyError(err).Kind != workflow.ErrorContextLength || shorter == nil {
+			return nil, err
+		}
+		status("The conversation is too long for the model, retrying with less history…")
+		attempt.trimmed = shorter
+	}
+}
 
+// receiveChatStream reads an opened stream to its end, adding the deltas to
+// answer and publishing it as they arrive. Reasoning is added to reasoning
+// unless that is nil. A stream cut off by the watchdog returns the
+// watchdog's timeout error.
+func receiveChatStream(stream workflow.ChatStream, watchdog *workflow.Watchdog, started bool, server *workflow.StreamServer, answer, reasoning *strings.Builder) (workflow.ChatResult, error) {
+	defer stream.Close()
+	defer watchdog.Stop()
+
+	publish := func() {
+		state := workflow.StreamState{Content: answer.String()}
+		if reasoning != nil {
+			state.Reasoning = reasoning.String()
+		}
+		server.Publish(state)
+	}
+	for ok := started; ok; ok = stream.Next() {
+		// A chunk may carry both the end of the thinking and the start of
+		// the answer.
+		thought, delta := stream.Reasoning(), stream.Delta()
+		if thought != "" && reasoning != nil {
+			reasoning.WriteString(thought)
+		}
+		if thought != "" && delta == "" {
+			// Thinking may pause for longer than the idle timeout, so it
+			// does not start the answer; the first-token timeout bounds how
+			// long the model thinks.
+			watchdog.Extend()
+		} else {
+			watchdog.Kick()
+		}
+		answer.WriteString(delta)
+		if delta != "" || (thought != "" && reasoning != nil) {
+			publish()
+		}
+	}
 	if err := stream.Err(); err != nil {
-		workflow.WriteStreamState(env.StreamFile, workflow.StreamState{Error: err.Error(), Content: builder.String()})
-		return err
+		if timeout := watchdog.TimedOut(); timeout != nil {
+			return stream.Result(), timeout
+		}
+		return stream.Result(), err
+	}
+	return stream.Result(), nil
+}
+
+// chatRequest asks model to answer messages, with the answer settings from
+// env.
+func chatRequest(env *workflow.Env, model, summary string, messages []workflow.Message, previousID string) workflow.ChatRequest {
+	return workflow.ChatRequest{
+		Model:              model,
+		System:             chatSystem(env, summary),
+		Messages:           messages,
+		PreviousResponseID: previousID,
+		MaxTokens:          env.MaxTokens,
+		ReasoningEffort:    env.ReasoningEffort,
+		Reasoning:          env.ShowReasoning,
+	}
+}
+
+func chatSystem(env *workflow.Env, summary string) []string {
+	var system []string
+	if env.SystemPrompt != "" {
+		system = append(system, env.SystemPrompt)
+	}
+	if summary != "" {
+		system = append(system, "Summary of the earlier conversation:\n"+summary)
+	}
+	return system
+}
+
+// openChatStream starts a streamed completion and waits for its first chunk.
+// Only this part is retried: once a chunk has arrived, part of the answer may
+// already be on screen. started is false for a stream that ended empty. model
+// is the model as configured, which sets how long the first chunk may take.
+// The returned watchdog enforces the idle timeout for the rest of the stream.
+func openChatStream(ctx context.Context, provider workflow.ChatProvider, env *workflow.Env, status func(string), model string, req workflow.ChatRequest) (stream workflow.ChatStream, watchdog *workflow.Watchdog, started bool, err error) {
+	err = workflow.Retry(ctx, env.Retry, status, func() error {
+		var streamCtx context.Context
+		streamCtx, watchdog = workflow.NewWatchdog(ctx, env.FirstTokenTimeoutFor(model), env.IdleTimeout())
+		var err error
+		stream, err = provider.StreamChat(streamCtx, req)
+		if err != nil {
+			timeout := watchdog.TimedOut()
+			watchdog.Stop()
+			if timeout != nil {
+				return timeout
+			}
+			return err
+		}
+		started = stream.Next()
+		if err := stream.Err(); err != nil {
+			stream.Close()
+			timeout := watchdog.TimedOut()
+			watchdog.Stop()
+			if timeout != nil {
+				return timeout
+			}
+			return err
+		}
+		return nil
+	})
+	return stream, watchdog, started, err
+}
+
+// droppedMessages counts the messages of sent that kept, a shortened suffix
+// of it, left out or cut.
+func droppedMessages(sent, kept []workflow.Message) int {
+	dropped := len(sent) - len(kept)
+	if len(kept) > 0 && kept[0].Content != sent[dropped].Content {
+		dropped++
 	}
+	return dropped
+}
+
+// recordUsage adds a request to the usage ledger. A failed write is logged
+// rather than returned, since the request itself already succeeded.
+func recordUsage(env *workflow.Env, rec workflow.UsageRecord) {
+	if err := workflow.AppendUsage(env.UsageFile, rec); err != nil {
+		fmt.Fprintln(os.Stderr, "usage error:", err)
+	}
+}
+
+// trimChat picks the context sent with the request: a token budget when
+// max_context_tokens is set, otherwise the last max_context messages.
+func trimChat(env *workflow.Env, model string, chat []workflow.Message) []workflow.Message {
+	if env.MaxContextTokens <= 0 {
+		return workflow.TrimContext(chat, env.MaxContext)
+	}
+	enc := workflow.EncodingForModel(model)
+	budget := env.MaxContextTokens
+	if env.SystemPrompt != "" {
+		budget -= workflow.MessageTokens(enc, workflow.Message{Role: "system", Content: env.SystemPrompt})
+	}
+	return workflow.TrimContextTokens(chat, enc, budget)
+}
 
-	finishReason := ""
-	if len(acc.Choices) > 0 {
-		finishReason = acc.Choices[0].FinishReason
+// updateSummary folds the first dropped messages into the rolling summary when
+// summarize_context is on, and returns the summary to send with the request.
+// Failures keep the previous summary so the answer itself is never blocked.
+func updateSummary(ctx context.Context, env *workflow.Env, status func(string), chat []workflow.Message, dropped int, model string) (string, error) {
+	if !env.SummarizeContext {
+		return "", nil
+	}
+	summary, err := workflow.ReadSummary(env.SummaryFile)
+	if err != nil || !summary.Matches(chat) {
+		summary = workflow.ChatSummary{}
+	}
+	if dropped <= summary.Covered {
+		return summary.Text, nil
 	}
 
-	return workflow.WriteStreamState(env.StreamFile, workflow.StreamState{
-		Content:      builder.String(),
-		FinishReason: finishReason,
+	provider, name, err := workflow.NewChatProvider(env, env.SummaryModelFor(model))
+	if err != nil {
+		return summary.Text, err
+	}
+	var text string
+	var result workflow.ChatResult
+	err = workflow.Retry(ctx, env.Retry, status, func() (err error) {
+		text, result, err = workflow.CompleteChat(ctx, provider, workflow.ChatRequest{
+			Model:    name,
+			System:   []string{workflow.SummaryInstructions},
+			Messages: []workflow.Message{{Role: "user", Content: workflow.SummaryPrompt(summary.Text, chat[summary.Covered:dropped])}},
+		})
+		return err
 	})
+	if err == nil && strings.TrimSpace(text) == "" {
+		err = errors.New("the summary came back empty")
+	}
+	if err != nil {
+		return summary.Text, err
+	}
+	summarizedBy := result.Model
+	if summarizedBy == "" {
+		summarizedBy = name
+	}
+	recordUsage(env, workflow.UsageRecord{
+		Time:             time.Now(),
+		Kind:             workflow.UsageChat,
+		Model:            summarizedBy,
+		PromptTokens:     result.PromptTokens,
+		CompletionTokens: result.CompletionTokens,
+	})
+
+	next := workflow.ChatSummary{
+		Text:    strings.TrimSpace(text),
+		Covered: dropped,
+		Digest:  workflow.SummaryDigest(chat[:dropped]),
+	}
+	if err := workflow.WriteSummary(env.SummaryFile, next); err != nil {
+		fmt.Fprintln(os.Stderr, "summary error:", err)
+	}
+	return next.Text, nil
+}
+
+func chatMarkdown(env *workflow.Env, chat []workflow.Message, ignoreLastInterrupted bool) string {
+	markdown := workflow.MarkdownChat(chat, ignoreLastInterrupted)
+	if !env.SummarizeContext {
+		return markdown
+	}
+	summary, err := workflow.ReadSummary(env.SummaryFile)
+	if err != nil || summary.Text == "" || !summary.Matches(chat) {
+		return markdown
+	}
+	return workflow.MarkdownSummary(summary.Text) + "\n\n" + markdown
 }
 
 func respondStream(env *workflow.Env, marker bool) error {
 	if marker {
 		resp := alfredResponse{
 			Rerun:     0.1,
-			Variables: map[string]string{"streaming_now": "1"},
+			Variables: map[string]string{"streaming_now": "1", "stream_offset": "0"},
 			Response:  "…",
 			Behaviour: map[string]string{"response": "append"},
 		}
 		return emit(resp)
 	}
 
+	// The text view already shows the answer up to stream_offset bytes, and
+	// each poll adds only what came after.
+	offset, _ := strconv.Atoi(os.Getenv("stream_offset"))
+
+	// Polls come ten times a second, so only the cheap check that the PID
+	// still runs is made while the stream process answers on its socket.
+	running := workflow.StreamProcessRunning(env.PIDFile)
+
+	// The stream process enforces its own timeouts and reports a stall as
+	// its finish reason. It updates the stream file at least once a second
+	// while it makes progress, so a file silent for longer than the timeouts
+	// allow means it is wedged, and it is stopped here instead. Any of the
+	// fallbacks may be the model answering, so the one given the longest to
+	// start sets the limit.
+	wedged := false
+	firstToken := env.FirstTokenTimeoutFor(workflow.ResolveChatModel(env.GPTModel, env.ChatModelOverride))
+	for _, fallback := range env.Fallbacks {
+		firstToken = max(firstToken, env.FirstTokenTimeoutFor(fallback))
+	}
+	if age, err := workflow.FileAge(env.StreamFile, time.Now()); running && err == nil && age > firstToken+env.IdleTimeout()+streamGrace {
+		workflow.SignalStreamProcess(env.PIDFile, syscall.SIGKILL)
+		wedged = true
+	}
+
+	// While the stream process serves its socket the answer is in progress.
+	// Once it is done the socket is gone and the final state is in the file.
+	if !wedged {
+		if update, err := workflow.RequestStreamUpdate(env.SocketFile, offset); err == nil {
+			return respondProgress(update.StreamState, offset)
+		}
+	}
+
+	if !workflow.StreamFileExists(env.StreamFile) {
+		chat, err := workflow.ReadChat(env.ChatFile)
+		if err != nil {
+			return respondError(err)
+		}
+		return emit(alfredResponse{
+			Response:  chatMarkdown(env, chat, false),
+			Behaviour: map[string]string{"scroll": "end"},
+		})
+	}
 	state, err := workflow.ReadStreamState(env.StreamFile)
 	if err != nil {
 		return respondError(err)
 	}
 
+	// Without its socket or a final state the stream process has either
+	// crashed or not started serving yet. Only now is the PID checked to
+	// still be ours, and a process seen gone is read again in case it
+	// finished in between.
+	alive := true
+	if !wedged && state.FinishReason == "" && state.Error == "" && !workflow.StreamProcessAlive(env.PIDFile) {
+		alive = false
+		if state, err = workflow.ReadStreamState(env.StreamFile); err != nil {
+			return respondError(err)
+		}
+	}
+
 	if state.Error != "" {
-		workflow.RemoveFiles(env.StreamFile, env.PIDFile)
+		workflow.RemoveFiles(env.StreamFile, env.PIDFile, env.SocketFile)
 		resp := alfredResponse{
-			Response:  state.Error,
+			Response:  workflow.APIError{Kind: state.ErrorKind, Detail: state.Error}.Markdown(),
 			Behaviour: map[string]string{"response": "replacelast"},
 		}
+		if offset > 0 {
+			resp.Response = "\n\n" + resp.Response
+			resp.Behaviour["response"] = "append"
+		}
 		return emit(resp)
 	}
 
-	now := time.Now()
-	age, err := workflow.FileAge(env.StreamFile, now)
-	stalled := err == nil && age > time.Duration(env.TimeoutSeconds)*time.Second
-
-	if state.FinishReason == "" && !stalled {
-		resp := alfredResponse{
-			Rerun:     0.1,
-			Variables: map[string]string{"streaming_now": "1"},
-			Response:  state.Content,
-			Behaviour: map[string]string{"response": "replacelast", "scroll": "end"},
-		}
-		return emit(resp)
+	if wedged && state.FinishReason == "" {
+		state.FinishReason = workflow.FinishStalled
 	}
+	stalled := state.FinishReason == workflow.FinishStalled
+	crashed := state.FinishReason == "" && !alive
 
-	chat, err := workflow.ReadChat(env.ChatFile)
-	if err != nil {
-		return respondError(err)
+	if state.FinishReason == "" && !stalled && !crashed {
+		// Without a socket the file holds the whole answer so far.
+		if offset < len(state.Content) {
+			state.Content = state.Content[offset:]
+		} else {
+			state.Content = ""
+		}
+		return respondProgress(state, offset)
 	}
 
 	assistantMessage := workflow.Message{Role: "assistant", Content: state.Content}
 	if state.Content != "" {
-		chat = append(chat, assistantMessage)
-		if err := workflow.WriteChat(env.ChatFile, chat); err != nil {
+		_, err := workflow.UpdateChat(env.ChatFile, func(chat []workflow.Message) ([]workflow.Message, error) {
+			regenerated := len(chat) > 0 && chat[len(chat)-1].Role == "assistant"
+			if regenerated {
+				assistantMessage = chat[len(chat)-1]
+				assistantMessage.AddVariant(state.Content)
+			}
+			assistantMessage.Created = time.Now().Unix()
+			assistantMessage.Model = state.Model
+			assistantMessage.PromptTokens = state.PromptTokens
+			assistantMessage.CompletionTokens = state.CompletionTokens
+			assistantMessage.FinishReason = state.FinishReason
+			assistantMessage.ResponseID = state.ResponseID
+			assistantMessage.Reasoning = state.Reasoning
+			if regenerated {
+				chat[len(chat)-1] = assistantMessage
+				return chat, nil
+			}
+			return append(chat, assistantMessage), nil
+		})
+		if err != nil {
 			return respondError(err)
 		}
 	}
 
-	workflow.RemoveFiles(env.StreamFile, env.PIDFile)
+	workflow.RemoveFiles(env.StreamFile, env.PIDFile, env.SocketFile)
 
 	footer := footerForFinish(state.FinishReason)
 	if stalled {
 		footer = "You can ask ChatGPT to continue the answer"
+		if strings.TrimSpace(state.Content) == "" {
+			footer = "No answer arrived in time · ⌘⇧↩ Retry"
+		}
+	}
+	if crashed {
+		footer = "The answer stopped unexpectedly · ⌘⇧↩ Retry"
+	}
+	if footer == "" {
+		footer = answerFooter(assistantMessage)
+	}
+	for _, note := range []string{fallbackFooter(state), droppedFooter(state.Dropped), summaryFooter(state.SummaryError)} {
+		if note == "" {
+			continue
+		}
+		if footer != "" {
+			footer += " · "
+		}
+		footer += note
 	}
 
+	suffix := ""
+	switch {
+	case crashed:
+		suffix = " [Answer Interrupted]"
+	case stalled:
+		suffix = " [Connection Stalled]"
+	case state.FinishReason == workflow.FinishCancelled:
+		suffix = "\n\n[Answer Stopped]"
+	}
+
+	behaviour := "replacelast"
 	responseText := state.Content
-	if stalled {
-		responseText = strings.TrimSpace(state.Content) + " [Connection Stalled]"
+	if offset > 0 {
+		// Only the rest of the answer is appended to what is shown. A crashed
+		// stream may have shown more than its last throttled write kept.
+		behaviour = "append"
+		responseText = state.Content[min(offset, len(state.Content)):]
+		if strings.HasSuffix(state.Content, " ") {
+			suffix = strings.TrimPrefix(suffix, " ")
+		}
+		responseText += suffix
+	} else {
+		if suffix != "" {
+			responseText = strings.TrimSpace(strings.TrimSpace(state.Content) + suffix)
+		}
+		if state.Reasoning != "" {
+			responseText = strings.TrimSpace(workflow.MarkdownReasoning(state.Reasoning, false) + "\n\n" + responseText)
+		}
 	}
 
 	resp := alfredResponse{
 		Response:  responseText,
 		Footer:    footer,
-		Behaviour: map[string]string{"response": "replacelast", "scroll": "end"},
+		Behaviour: map[string]string{"response": behaviour, "scroll": "end"},
 	}
 	return emit(resp)
 }
 
+// respondProgress shows an answer in progress. state.Content holds only the
+// text after offset, which is appended to what is shown; the first text
+// instead replaces the placeholder or status line. Until then the reasoning
+// is shown as it arrives, and it is collapsed above the answer once that
+// starts.
+func respondProgress(state workflow.StreamState, offset int) error {
+	behaviour := "append"
+	response := state.Content
+	if offset == 0 {
+		behaviour = "replacelast"
+		switch {
+		case state.Reasoning != "" && response == "":
+			response = workflow.MarkdownReasoning(state.Reasoning, true)
+		case state.Reasoning != "":
+			response = workflow.MarkdownReasoning(state.Reasoning, false) + "\n\n" + response
+		case response == "" && state.Status != "":
+			response = "*" + state.Status + "*"
+		}
+	}
+	return emit(alfredResponse{
+		Rerun:     0.1,
+		Variables: map[string]string{"streaming_now": "1", "stream_offset": strconv.Itoa(offset + len(state.Content))},
+		Response:  response,
+		Behaviour: map[string]string{"response": behaviour, "scroll": "end"},
+	})
+}
+
+// fallbackFooter names the model that answered when it was not the one asked.
+func fallbackFooter(state workflow.StreamState) string {
+	if state.FallbackFor == "" {
+		return ""
+	}
+	return fmt.Sprintf("Answered by %s because %s was unavailable", state.Model, state.FallbackFor)
+}
+
+func droppedFooter(dropped int) string {
+	switch {
+	case dropped == 1:
+		return "1 older message was left out to fit the model’s context"
+	case dropped > 1:
+		return fmt.Sprintf("%d older messages were left out to fit the model’s context", dropped)
+	}
+	return ""
+}
+
+func summaryFooter(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return "Older messages could not be summarised: " + singleLine(reason)
+}
+
 func footerForFinish(reason string) string {
 	switch reason {
 	case "length":
 		return "Maximum number of tokens reached"
 	case "content_filter":
 		return "Content was omitted due to a flag from OpenAI content filters"
+	case workflow.FinishCancelled:
+		return "Answer stopped"
 	default:
 		return ""
 	}
 }
 
-func dumpChat(path string) error {
+// dumpChat prints the chat at the given path as JSON, or without one the chat
+// of the conversation in conversation_id.
+func dumpChat(args []string) error {
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		env, err := workflow.LoadEnv()
+		if err != nil {
+			return err
+		}
+		path = env.ChatFile
+	}
 	messages, err := workflow.ReadChat(path)
 	if err != nil {
 		return err
@@ -365,10 +1093,22 @@ func respondError(err error) error {
 }
 
 func emit(resp alfredResponse) error {
-	data, err := json.Marshal(resp)
+	return emitJSON(resp)
+}
+
+func emitJSON(v any) error {
+	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 	fmt.Println(string(data))
 	return nil
 }
+
+func emitItems(items []scriptFilterItem) error {
+	return emitJSON(scriptFilterResponse{Items: items})
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
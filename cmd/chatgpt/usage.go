@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/openai-workflow/workflow/internal/workflow"
+)
+
+// showUsage renders spend by model from the usage ledger. The optional
+// argument picks daily, weekly or monthly periods.
+func showUsage(args []string) error {
+	env, err := workflow.LoadEnv()
+	if err != nil {
+		return respondError(err)
+	}
+	period, err := workflow.ParseUsagePeriod(strings.Join(args, " "))
+	if err != nil {
+		return respondError(err)
+	}
+	prices, err := workflow.LoadPrices()
+	if err != nil {
+		return respondError(err)
+	}
+	records, err := workflow.ReadUsage(env.UsageFile)
+	if err != nil {
+		return respondError(err)
+	}
+	return emit(alfredResponse{Response: workflow.MarkdownUsage(records, prices, period, time.Now())})
+}
@@ -35,8 +35,8 @@ func run() error {
 	if err != nil {
 		return respondError(err)
 	}
-	if env.APIKey == "" {
-		return respondError(fmt.Errorf("OpenAI API key missing"))
+	if err := env.CheckAPIKey(workflow.ProviderOpenAI); err != nil {
+		return respondError(err)
 	}
 
 	dalleEnv, err := workflow.LoadDalleEnv()
@@ -81,9 +81,11 @@ func run() error {
 	}
 
 	client, err := workflow.NewClient(workflow.ClientOptions{
-		APIKey:  env.APIKey,
-		OrgID:   env.OrgID,
-		BaseURL: workflow.NormalizeBaseURL(env.DalleAPIEndpoint, "https://api.openai.com/v1", "/images/generations"),
+		APIKey:         env.APIKey,
+		OrgID:          env.OrgID,
+		BaseURL:        workflow.NormalizeBaseURL(env.DalleAPIEndpoint, "https://api.openai.com/v1", "/images/generations"),
+		ConnectTimeout: env.ConnectTimeout,
+		Azure:          env.Azure,
 	})
 	if err != nil {
 		return respondError(err)
@@ -98,6 +100,9 @@ func run() error {
 
 	if dalleEnv.Model != "" {
 		params.Model = openai.ImageModel(dalleEnv.Model)
+	} else if env.Azure != nil {
+		// Azure has no default image model to fall back on, and no DALL·E 2.
+		params.Model = openai.ImageModelDallE3
 	}
 	if dalleEnv.Style != "" {
 		params.Style = openai.ImageGenerateParamsStyle(dalleEnv.Style)
@@ -106,14 +111,23 @@ func run() error {
 		params.Quality = openai.ImageGenerateParamsQuality(dalleEnv.Quality)
 	}
 
+	if err := workflow.CheckBudget(env, env.DalleBudget, imageUsage(params, nil)); err != nil {
+		return respondWithPreviousError(previousResponse, typedQuery, err)
+	}
+
 	ctx := context.Background()
-	imagesResp, err := client.Images.Generate(ctx, params)
+	var imagesResp *openai.ImagesResponse
+	err = workflow.Retry(ctx, env.Retry, logRetry, func() (err error) {
+		imagesResp, err = client.Images.Generate(ctx, params)
+		return err
+	})
 	if err != nil {
 		return respondWithPreviousError(previousResponse, typedQuery, err)
 	}
+	recordUsage(env, params, imagesResp)
 
 	creation := time.Unix(imagesResp.Created, 0)
-	downloaded, err := downloadImages(ctx, imagesResp.Data, typedQuery, dalleEnv, creation)
+	downloaded, err := downloadImages(ctx, env.Retry, imagesResp.Data, typedQuery, dalleEnv, creation)
 	if err != nil {
 		return respondWithPreviousError(previousResponse, typedQuery, err)
 	}
@@ -143,7 +157,7 @@ func previousMarkdown(folder string, max int) ([]string, error) {
 	return markdown, nil
 }
 
-func downloadImages(ctx context.Context, data []openai.Image, prompt string, dalleEnv *workflow.DalleEnv, creation time.Time) ([]string, error) {
+func downloadImages(ctx context.Context, policy workflow.RetryPolicy, data []openai.Image, prompt string, dalleEnv *workflow.DalleEnv, creation time.Time) ([]string, error) {
 	client := &http.Client{Timeout: 60 * time.Second}
 	var paths []string
 
@@ -151,21 +165,13 @@ func downloadImages(ctx context.Context, data []openai.Image, prompt string, dal
 		if item.URL == "" {
 			return nil, fmt.Errorf("image response missing URL")
 		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.URL, nil)
-		if err != nil {
-			return nil, err
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		var body []byte
+		err := workflow.Retry(ctx, policy, logRetry, func() (err error) {
+			body, err = downloadImage(ctx, client, item.URL)
+			return err
+		})
 		if err != nil {
-			return nil, err
-		}
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return nil, fmt.Errorf("image download failed: %s", resp.Status)
+			return nil, fmt.Errorf("image download failed: %w", err)
 		}
 
 		uid := workflow.RandomUID()
@@ -191,6 +197,76 @@ func downloadImages(ctx context.Context, data []openai.Image, prompt string, dal
 	return paths, nil
 }
 
+// recordUsage adds the generation to the usage ledger.
+func recordUsage(env *workflow.Env, params openai.ImageGenerateParams, resp *openai.ImagesResponse) {
+	if err := workflow.AppendUsage(env.UsageFile, imageUsage(params, resp)); err != nil {
+		fmt.Fprintln(os.Stderr, "usage error:", err)
+	}
+}
+
+// imageUsage describes a generation for the ledger, or the one about to be
+// requested when resp is nil. Parameters the request left to the API are
+// filled in with the API's defaults so the images can be priced.
+func imageUsage(params openai.ImageGenerateParams, resp *openai.ImagesResponse) workflow.UsageRecord {
+	rec := workflow.UsageRecord{
+		Time:    time.Now(),
+		Kind:    workflow.UsageImage,
+		Model:   string(params.Model),
+		Images:  int(params.N.Value),
+		Size:    string(params.Size),
+		Quality: string(params.Quality),
+	}
+	if resp != nil {
+		rec.Images = len(resp.Data)
+		rec.PromptTokens = resp.Usage.InputTokens
+		rec.CompletionTokens = resp.Usage.OutputTokens
+		if resp.Size != "" {
+			rec.Size = string(resp.Size)
+		}
+		if resp.Quality != "" {
+			rec.Quality = string(resp.Quality)
+		}
+	}
+	if rec.Model == "" {
+		rec.Model = "dall-e-2"
+	}
+	if strings.HasPrefix(rec.Model, "gpt-image") && resp == nil {
+		// Estimate what auto could pick at most.
+		if rec.Quality == "" || rec.Quality == "auto" {
+			rec.Quality = "high"
+		}
+		if rec.Size == "" || rec.Size == "auto" {
+			rec.Size = "1536x1024"
+		}
+	}
+	if rec.Quality == "" {
+		rec.Quality = "standard"
+	}
+	return rec
+}
+
+func downloadImage(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &workflow.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// logRetry notes waits on stderr; the image view only updates once the
+// request finishes, so there is nowhere to show them.
+func logRetry(status string) {
+	fmt.Fprintln(os.Stderr, status)
+}
+
 func buildPromptText(original, revised string) string {
 	if strings.TrimSpace(revised) == "" {
 		return fmt.Sprintf("Original Prompt: %s", original)
@@ -203,7 +279,7 @@ func respondWithPreviousError(previous, prompt string, err error) error {
 	if message != "" {
 		message += "\n\n"
 	}
-	message += fmt.Sprintf("**Original Prompt:** %s\n\n%s", prompt, err.Error())
+	message += fmt.Sprintf("**Original Prompt:** %s\n\n%s", prompt, workflow.ClassifyError(err).Markdown())
 	resp := alfredResponse{
 		Response:  message,
 		Behaviour: map[string]string{"response": "append", "scroll": "end"},
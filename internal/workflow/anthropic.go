@@ -0,0 +1,214 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// The Messages API requires a cap on the answer's length; this one is
+	// within what every current Claude model can produce.
+	anthropicMaxTokens = 4096
+)
+
+// Extended thinking budgets for each reasoning_effort. Thinking is left off
+// for minimal effort, as it is by default.
+var anthropicThinkingBudget = map[string]int{
+	"low":    1024,
+	"medium": 4096,
+	"high":   16384,
+}
+
+// anthropicCanThink reports whether model supports extended thinking, which
+// Claude models before 3.7 reject.
+func anthropicCanThink(model string) bool {
+	return !strings.HasPrefix(model, "claude-3-") || strings.HasPrefix(model, "claude-3-7-")
+}
+
+// anthropicProvider speaks Anthropic's Messages API.
+type anthropicProvider struct {
+	config ProviderConfig
+	client *http.Client
+}
+
+func (p *anthropicProvider) StreamChat(ctx context.Context, req ChatRequest) (ChatStream, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	type thinking struct {
+		Type         string `json:"type"`
+		BudgetTokens int    `json:"budget_tokens"`
+	}
+	body := struct {
+		Model     string    `json:"model"`
+		MaxTokens int       `json:"max_tokens"`
+		System    string    `json:"system,omitempty"`
+		Messages  []message `json:"messages"`
+		Thinking  *thinking `json:"thinking,omitempty"`
+		Stream    bool      `json:"stream"`
+	}{
+		Model:     req.Model,
+		MaxTokens: anthropicMaxTokens,
+		System:    strings.Join(req.System, "\n\n"),
+		Stream:    true,
+	}
+	if req.MaxTokens > 0 {
+		body.MaxTokens = req.MaxTokens
+	}
+	if budget := anthropicThinkingBudget[req.ReasoningEffort]; budget > 0 && anthropicCanThink(req.Model) {
+		// Thinking counts towards max_tokens, so the answer's own allowance
+		// comes on top of the budget.
+		body.Thinking = &thinking{Type: "enabled", BudgetTokens: budget}
+		body.MaxTokens += budget
+	}
+	for _, m := range alternateTurns(req.Messages) {
+		body.Messages = append(body.Messages, message{Role: m.Role, Content: m.Content})
+	}
+
+	header := http.Header{}
+	header.Set("X-Api-Key", p.config.APIKey)
+	header.Set("Anthropic-Version", anthropicVersion)
+	base := NormalizeBaseURL(p.config.Endpoint, "https://api.anthropic.com/v1", "/messages")
+	resp, err := postStream(ctx, p.client, base+"/messages", header, body, func(httpErr *HTTPError, data []byte) {
+		var failure struct {
+			Error anthropicError `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil {
+			failure.Error.describe(httpErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &anthropicStream{body: resp.Body, events: newSSEReader(resp.Body)}, nil
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Statuses for errors reported in the middle of a stream, which arrive
+// without one.
+var anthropicErrorStatus = map[string]int{
+	"rate_limit_error": http.StatusTooManyRequests,
+	"api_error":        http.StatusInternalServerError,
+	"overloaded_error": 529,
+}
+
+// describe fills in httpErr from the error Anthropic reported, with the codes
+// ClassifyError knows from OpenAI.
+func (e anthropicError) describe(httpErr *HTTPError) {
+	httpErr.Message = e.Message
+	if httpErr.StatusCode == 0 {
+		httpErr.StatusCode = anthropicErrorStatus[e.Type]
+	}
+	message := strings.ToLower(e.Message)
+	switch {
+	case e.Type == "authentication_error":
+		httpErr.Code = "invalid_api_key"
+	case strings.Contains(message, "credit balance"):
+		httpErr.Code = "insufficient_quota"
+	case strings.Contains(message, "prompt is too long"):
+		httpErr.Code = "context_length_exceeded"
+	case e.Type == "not_found_error" && strings.Contains(message, "model"):
+		httpErr.Code = "model_not_found"
+	}
+}
+
+type anthropicUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+type anthropicStream struct {
+	body      io.Closer
+	events    *sseReader
+	delta     string
+	reasoning string
+	result    ChatResult
+	err       error
+}
+
+func (s *anthropicStream) Next() bool {
+	s.delta, s.reasoning = "", ""
+	for {
+		_, data, ok := s.events.next()
+		if !ok {
+			s.err = s.events.err()
+			return false
+		}
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				Model string         `json:"model"`
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Delta struct {
+				Type       string `json:"type"`
+				Text       string `json:"text"`
+				Thinking   string `json:"thinking"`
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage anthropicUsage `json:"usage"`
+			Error anthropicError `json:"error"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			s.err = err
+			return false
+		}
+		switch event.Type {
+		case "message_start":
+			usage := event.Message.Usage
+			s.result.Model = event.Message.Model
+			s.result.PromptTokens = usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+			s.result.CompletionTokens = usage.OutputTokens
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				s.delta = event.Delta.Text
+				return true
+			case "thinking_delta":
+				s.reasoning = event.Delta.Thinking
+				return true
+			}
+		case "message_delta":
+			s.result.FinishReason = anthropicFinishReason(event.Delta.StopReason)
+			if event.Usage.OutputTokens > 0 {
+				s.result.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return false
+		case "error":
+			httpErr := &HTTPError{Status: event.Error.Type}
+			event.Error.describe(httpErr)
+			s.err = httpErr
+			return false
+		}
+	}
+}
+
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "refusal":
+		return "content_filter"
+	}
+	return reason
+}
+
+func (s *anthropicStream) Delta() string      { return s.delta }
+func (s *anthropicStream) Reasoning() string  { return s.reasoning }
+func (s *anthropicStream) Err() error         { return s.err }
+func (s *anthropicStream) Result() ChatResult { return s.result }
+func (s *anthropicStream) Close() error       { return s.body.Close() }
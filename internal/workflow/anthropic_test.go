@@ -0,0 +1,154 @@
+package workflow
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAnthropicStream(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(
+		`{"type":"message_start","message":{"model":"claude-3-7-sonnet-20250219","usage":{"input_tokens":10,"cache_read_input_tokens":2,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me think."}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hel"}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"lo"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":7}}`,
+		`{"type":"message_stop"}`,
+	))
+	provider := &anthropicProvider{config: ProviderConfig{APIKey: "key", Endpoint: api.URL}, client: http.DefaultClient}
+	got, err := readStream(t, provider, ChatRequest{
+		Model:           "claude-3-7-sonnet-20250219",
+		System:          []string{"Be brief.", "Summary"},
+		Messages:        []Message{{Role: "assistant", Content: "Hi"}, {Role: "user", Content: "a"}, {Role: "user", Content: "b"}},
+		ReasoningEffort: "high",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if got.answer() != "Hello" || len(got.deltas) != 2 {
+		t.Errorf("deltas = %q, want two making Hello", got.deltas)
+	}
+	if len(got.reasoning) != 1 || got.reasoning[0] != "Let me think." {
+		t.Errorf("reasoning = %q", got.reasoning)
+	}
+	want := ChatResult{Model: "claude-3-7-sonnet-20250219", FinishReason: "length", PromptTokens: 12, CompletionTokens: 7}
+	if got.result != want {
+		t.Errorf("result = %+v, want %+v", got.result, want)
+	}
+
+	if api.request.URL.Path != "/v1/messages" {
+		t.Errorf("path = %s", api.request.URL.Path)
+	}
+	if api.request.Header.Get("X-Api-Key") != "key" || api.request.Header.Get("Anthropic-Version") != anthropicVersion {
+		t.Errorf("headers = %v", api.request.Header)
+	}
+	body := api.requestBody
+	if body["system"] != "Be brief.\n\nSummary" || body["stream"] != true {
+		t.Errorf("body = %v", body)
+	}
+	if body["max_tokens"] != float64(anthropicMaxTokens+16384) {
+		t.Errorf("max_tokens = %v, want the answer's allowance plus the thinking budget", body["max_tokens"])
+	}
+	if thinking, _ := body["thinking"].(map[string]any); thinking["budget_tokens"] != float64(16384) {
+		t.Errorf("thinking = %v", body["thinking"])
+	}
+	if messages, _ := body["messages"].([]any); len(messages) != 1 {
+		t.Errorf("messages = %v, want one alternated user turn", body["messages"])
+	}
+}
+
+func TestAnthropicThinkingOnlyWhereSupported(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(`{"type":"message_stop"}`))
+	provider := &anthropicProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+	for _, tt := range []struct {
+		model, effort string
+		thinks        bool
+	}{
+		{"claude-3-5-sonnet-20241022", "high", false},
+		{"claude-sonnet-4-5", "minimal", false},
+		{"claude-sonnet-4-5", "", false},
+		{"claude-sonnet-4-5", "low", true},
+	} {
+		if _, err := readStream(t, provider, ChatRequest{Model: tt.model, ReasoningEffort: tt.effort}); err != nil {
+			t.Fatal(err)
+		}
+		if _, thinks := api.requestBody["thinking"]; thinks != tt.thinks {
+			t.Errorf("%s at %q effort: thinking sent = %v, want %v", tt.model, tt.effort, thinks, tt.thinks)
+		}
+	}
+}
+
+func TestAnthropicFinishReason(t *testing.T) {
+	tests := map[string]string{
+		"end_turn":      "stop",
+		"stop_sequence": "stop",
+		"max_tokens":    "length",
+		"refusal":       "content_filter",
+		"tool_use":      "tool_use",
+	}
+	for reason, want := range tests {
+		if got := anthropicFinishReason(reason); got != want {
+			t.Errorf("anthropicFinishReason(%q) = %q, want %q", reason, got, want)
+		}
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   ErrorKind
+	}{
+		{"bad key", 401, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, ErrorAuth},
+		{"no credit", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low"}}`, ErrorQuota},
+		{"too long", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, ErrorContextLength},
+		{"unknown model", 404, `{"type":"error","error":{"type":"not_found_error","message":"model: claude-9"}}`, ErrorModel},
+		{"rate limited", 429, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`, ErrorRateLimit},
+		{"overloaded", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, ErrorServer},
+		{"not JSON", 502, `<html>Bad Gateway</html>`, ErrorServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, tt.status, "application/json", tt.body)
+			provider := &anthropicProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+			_, err := readStream(t, provider, ChatRequest{Model: "claude-sonnet-4-5"})
+			if got := errorKind(err); got != tt.want {
+				t.Errorf("error %v classified as %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicErrorInStream(t *testing.T) {
+	tests := []struct {
+		errorType string
+		want      ErrorKind
+	}{
+		{"overloaded_error", ErrorServer},
+		{"api_error", ErrorServer},
+		{"rate_limit_error", ErrorRateLimit},
+	}
+	for _, tt := range tests {
+		api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(
+			`{"type":"message_start","message":{"model":"claude-sonnet-4-5","usage":{"input_tokens":3}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Par"}}`,
+			`{"type":"error","error":{"type":"`+tt.errorType+`","message":"failed"}}`,
+		))
+		provider := &anthropicProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+		got, err := readStream(t, provider, ChatRequest{Model: "claude-sonnet-4-5"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.answer() != "Par" {
+			t.Errorf("%s: answer = %q, want what came before the error", tt.errorType, got.answer())
+		}
+		if kind := errorKind(got.err); kind != tt.want {
+			t.Errorf("%s: error %v classified as %q, want %q", tt.errorType, got.err, kind, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package workflow
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	openai "github.com/openai/openai-go"
+)
+
+// ErrorKind groups failures by what the user can do about them.
+type ErrorKind string
+
+const (
+	ErrorAuth           ErrorKind = "auth"
+	ErrorPermission     ErrorKind = "permission"
+	ErrorQuota          ErrorKind = "quota"
+	ErrorRateLimit      ErrorKind = "rate_limit"
+	ErrorContextLength  ErrorKind = "context_length"
+	ErrorModel          ErrorKind = "model"
+	ErrorContentPolicy  ErrorKind = "content_policy"
+	ErrorInvalidRequest ErrorKind = "invalid_request"
+	ErrorServer         ErrorKind = "server"
+	ErrorNetwork        ErrorKind = "network"
+	ErrorTLS            ErrorKind = "tls"
+	ErrorProxy          ErrorKind = "proxy"
+)
+
+// APIError is a failed request reduced to its kind and the server's own
+// message, which is all the stream file needs to carry for the error to be
+// explained later. Kind is empty for errors that fit no group.
+type APIError struct {
+	Kind   ErrorKind
+	Detail string
+}
+
+var errorHelp = map[ErrorKind]struct{ summary, hint string }{
+	ErrorAuth:           {"The API key was rejected.", "Check the provider’s API key, such as `openai_api_key`, in the workflow’s configuration."},
+	ErrorPermission:     {"The API key is not allowed to make this request.", "Check the key’s project permissions and `openai_org_id`."},
+	ErrorQuota:          {"Your account has run out of credits.", "Add credits on the provider’s billing page, for OpenAI [here](https://platform.openai.com/account/billing/overview)."},
+	ErrorRateLimit:      {"Too many requests were sent in a short time.", "Wait a moment and try again, or raise `max_retries`."},
+	ErrorContextLength:  {"The conversation is too long for the model.", "Start a new chat, or set `max_context_tokens` to keep the context within the model’s limit."},
+	ErrorModel:          {"The model is not available to this API key.", "Check the model name in `gpt_model`, `chatgpt_model_override` or `dalle_model`."},
+	ErrorContentPolicy:  {"The request was refused by the content policy.", "Rephrase the prompt and try again."},
+	ErrorInvalidRequest: {"The API rejected the request.", "A workflow variable may hold a value the endpoint does not accept."},
+	ErrorServer:         {"The API had a server error.", "Try again shortly, and check the provider’s status page, such as [OpenAI’s](https://status.openai.com), if it persists."},
+	ErrorNetwork:        {"The API could not be reached.", "Check your internet connection and the API endpoints in the workflow’s configuration."},
+	ErrorTLS:            {"The secure connection to the API failed.", "The endpoint’s certificate was not accepted; a proxy or security software may be intercepting HTTPS."},
+	ErrorProxy:          {"The connection through the proxy failed.", "Check the `https_proxy` variable or the proxy in Alfred’s network settings."},
+}
+
+// ClassifyError works out why a request failed.
+func ClassifyError(err error) APIError {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		detail := apiErr.Message
+		if detail == "" {
+			detail = err.Error()
+		}
+		return APIError{Kind: classifyResponse(apiErr.StatusCode, apiErr.Code, apiErr.Message), Detail: detail}
+	}
+	// Other providers' errors; a bare status, as from an image download, is
+	// left as it is.
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.Message != "" {
+		return APIError{Kind: classifyResponse(httpErr.StatusCode, httpErr.Code, httpErr.Message), Detail: httpErr.Message}
+	}
+
+	classified := APIError{Detail: err.Error()}
+	var opErr *net.OpError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		classified.Kind = ErrorProxy
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert), errors.As(err, &hostname), errors.As(err, &recordHeader):
+		classified.Kind = ErrorTLS
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED):
+		classified.Kind = ErrorNetwork
+	}
+	return classified
+}
+
+func classifyResponse(status int, code, message string) ErrorKind {
+	switch code {
+	case "invalid_api_key":
+		return ErrorAuth
+	case "insufficient_quota":
+		return ErrorQuota
+	case "context_length_exceeded", "string_above_max_length":
+		return ErrorContextLength
+	case "model_not_found":
+		return ErrorModel
+	case "content_policy_violation", "content_filter":
+		return ErrorContentPolicy
+	}
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrorAuth
+	case status == http.StatusForbidden:
+		return ErrorPermission
+	case status == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case status == http.StatusNotFound && strings.Contains(strings.ToLower(message), "model"):
+		return ErrorModel
+	case status >= 500:
+		return ErrorServer
+	case status >= 400:
+		return ErrorInvalidRequest
+	}
+	return ""
+}
+
+// Summary is the error in one sentence, for a footer.
+func (e APIError) Summary() string {
+	if help, ok := errorHelp[e.Kind]; ok {
+		return help.summary
+	}
+	return e.Detail
+}
+
+// Markdown explains the error and what to do next, with the server's message
+// quoted below. Unclassified errors are shown as they are.
+func (e APIError) Markdown() string {
+	help, ok := errorHelp[e.Kind]
+	if !ok {
+		return e.Detail
+	}
+	markdown := "**" + help.summary + "** " + help.hint
+	if detail := strings.TrimSpace(e.Detail); detail != "" {
+		markdown += "\n\n> " + strings.Join(strings.Split(detail, "\n"), "\n> ")
+	}
+	return markdown
+}
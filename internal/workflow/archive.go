@@ -0,0 +1,178 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const archiveTimeLayout = "2006.01.02.15.04.05"
+
+var archiveIDPattern = regexp.MustCompile(`^[0-9]{4}(\.[0-9]{2}){5}-[A-Za-z0-9]+$`)
+
+type ArchiveEntry struct {
+	ID            string
+	Path          string
+	Created       time.Time
+	FirstQuestion string
+	LastQuestion  string
+	Messages      int
+}
+
+func ArchiveDir(dataDir string) string {
+	return filepath.Join(dataDir, "archive")
+}
+
+// ArchiveID accepts either a bare archive ID or the path of an archived chat.
+func ArchiveID(idOrPath string) (string, error) {
+	id := strings.TrimSuffix(filepath.Base(idOrPath), ".json")
+	if !archiveIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid archive id %q", idOrPath)
+	}
+	return id, nil
+}
+
+func ArchivePath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+// ArchiveChat moves the chat at chatPath into dir under a timestamped name and
+// leaves an empty chat behind. Empty chats are not archived, in which case the
+// returned ID is empty.
+func ArchiveChat(chatPath, dir string, now time.Time) (string, error) {
+	unlock, err := lockFile(chatPath)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return archiveChat(chatPath, dir, now)
+}
+
+// archiveChat is ArchiveChat for a caller holding the chat's lock.
+func archiveChat(chatPath, dir string, now time.Time) (string, error) {
+	tree, err := ReadChatTree(chatPath)
+	if err != nil {
+		return "", err
+	}
+	if len(tree.Messages) == 0 {
+		RemoveFiles(SummaryPath(chatPath))
+		return "", nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	id := now.Format(archiveTimeLayout) + "-" + RandomUID()
+	dest := ArchivePath(dir, id)
+	if err := WriteChatTree(dest, tree); err != nil {
+		return "", err
+	}
+	if err := moveIfExists(SummaryPath(chatPath), SummaryPath(dest)); err != nil {
+		return "", err
+	}
+	return id, WriteChatTree(chatPath, &ChatTree{})
+}
+
+// RestoreArchive archives the current chat and makes the archived chat id
+// the current one again.
+func RestoreArchive(chatPath, dir, id string, now time.Time) error {
+	src := ArchivePath(dir, id)
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	tree, err := ReadChatTree(src)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(chatPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := archiveChat(chatPath, dir, now); err != nil {
+		return err
+	}
+	if err := WriteChatTree(chatPath, tree); err != nil {
+		return err
+	}
+	if err := moveIfExists(SummaryPath(src), SummaryPath(chatPath)); err != nil {
+		return err
+	}
+	RemoveFiles(src)
+	return nil
+}
+
+// ListArchives returns archived chats, newest first. Archives that decode but
+// hold no question are moved to the Trash; ones that cannot be read are
+// skipped.
+func ListArchives(dir string) ([]ArchiveEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var archives []ArchiveEntry
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		msgs, err := ReadChat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "skipping archive:", entry.Name(), err)
+			continue
+		}
+		archive := ArchiveEntry{
+			ID:       strings.TrimSuffix(entry.Name(), ".json"),
+			Path:     path,
+			Messages: len(msgs),
+		}
+		for _, m := range msgs {
+			if m.Role != "user" {
+				continue
+			}
+			if archive.FirstQuestion == "" {
+				archive.FirstQuestion = m.Content
+			}
+			archive.LastQuestion = m.Content
+		}
+		if archive.FirstQuestion == "" {
+			if err := TrashFiles(path, SummaryPath(path)); err != nil {
+				fmt.Fprintln(os.Stderr, "keeping empty archive:", entry.Name(), err)
+			}
+			continue
+		}
+		archive.Created = archiveCreated(archive.ID, entry)
+		archives = append(archives, archive)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ID > archives[j].ID
+	})
+	return archives, nil
+}
+
+func archiveCreated(id string, entry fs.DirEntry) time.Time {
+	if stamp, _, ok := strings.Cut(id, "-"); ok {
+		if t, err := time.ParseInLocation(archiveTimeLayout, stamp, time.Local); err == nil {
+			return t
+		}
+	}
+	if info, err := entry.Info(); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func moveIfExists(src, dest string) error {
+	if err := os.Rename(src, dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
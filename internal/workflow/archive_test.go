@@ -0,0 +1,226 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestChat(t *testing.T, path string, msgs ...Message) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tree := &ChatTree{}
+	tree.SetBranch(msgs)
+	if err := WriteChatTree(path, tree); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestChat(t *testing.T, path string) []Message {
+	t.Helper()
+	msgs, err := ReadChat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestArchiveID(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"2024.05.01.10.20.30-AbC123", "2024.05.01.10.20.30-AbC123", true},
+		{"/data/archive/2024.05.01.10.20.30-AbC123.json", "2024.05.01.10.20.30-AbC123", true},
+		{"2024.05.01.10.20.30", "", false},
+		{"2024.05.01-AbC123", "", false},
+		{"../2024.05.01.10.20.30-Ab/C", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ArchiveID(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ArchiveID(%q) = %q, %v; want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestArchiveChat(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	dir := t.TempDir()
+	chatPath := filepath.Join(dir, "chat.json")
+	archiveDir := ArchiveDir(dir)
+	writeTestChat(t, chatPath, Message{Role: "user", Content: "q"}, Message{Role: "assistant", Content: "a"})
+	if err := os.WriteFile(SummaryPath(chatPath), []byte("summary"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 5, 1, 10, 20, 30, 0, time.Local)
+	id, err := ArchiveChat(chatPath, archiveDir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ArchiveID(id); err != nil || id[:19] != "2024.05.01.10.20.30" {
+		t.Fatalf("archive id = %q, want one stamped with the time", id)
+	}
+	if got := readTestChat(t, ArchivePath(archiveDir, id)); len(got) != 2 || got[0].Content != "q" {
+		t.Errorf("archived chat = %+v", got)
+	}
+	if data, err := os.ReadFile(SummaryPath(ArchivePath(archiveDir, id))); err != nil || string(data) != "summary" {
+		t.Errorf("archived summary = %q, %v; want it moved with the chat", data, err)
+	}
+	if exists(SummaryPath(chatPath)) {
+		t.Error("summary left next to the current chat")
+	}
+	if got := readTestChat(t, chatPath); len(got) != 0 {
+		t.Errorf("current chat = %+v, want it empty", got)
+	}
+
+	// An empty chat is not archived, but its stale summary goes.
+	if err := os.WriteFile(SummaryPath(chatPath), []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	id, err = ArchiveChat(chatPath, archiveDir, now.Add(time.Second))
+	if err != nil || id != "" {
+		t.Errorf("archiving an empty chat = %q, %v; want no archive", id, err)
+	}
+	if exists(SummaryPath(chatPath)) {
+		t.Error("stale summary of an empty chat kept")
+	}
+	if entries, _ := os.ReadDir(archiveDir); len(entries) != 2 {
+		t.Errorf("archive holds %d files, want the one chat and its summary", len(entries))
+	}
+}
+
+func TestRestoreArchive(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	dir := t.TempDir()
+	chatPath := filepath.Join(dir, "chat.json")
+	archiveDir := ArchiveDir(dir)
+	const oldID = "2024.05.01.10.20.30-old"
+	oldPath := ArchivePath(archiveDir, oldID)
+	writeTestChat(t, oldPath, Message{Role: "user", Content: "old question"})
+	if err := os.WriteFile(SummaryPath(oldPath), []byte("old summary"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeTestChat(t, chatPath, Message{Role: "user", Content: "current question"})
+
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.Local)
+	if err := RestoreArchive(chatPath, archiveDir, oldID, now); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestChat(t, chatPath); len(got) != 1 || got[0].Content != "old question" {
+		t.Errorf("current chat = %+v, want the restored one", got)
+	}
+	if data, err := os.ReadFile(SummaryPath(chatPath)); err != nil || string(data) != "old summary" {
+		t.Errorf("current summary = %q, %v; want the restored chat's", data, err)
+	}
+	if exists(oldPath) || exists(SummaryPath(oldPath)) {
+		t.Error("restored archive left in the archive folder")
+	}
+
+	archives, err := ListArchives(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 || archives[0].FirstQuestion != "current question" || !archives[0].Created.Equal(now) {
+		t.Errorf("archives = %+v, want the chat that was current", archives)
+	}
+
+	if err := RestoreArchive(chatPath, archiveDir, "2024.05.01.10.20.30-missing", now); err == nil {
+		t.Error("restoring a missing archive succeeded")
+	}
+}
+
+func TestListArchives(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	trash := filepath.Join(home, ".Trash")
+	if err := os.Mkdir(trash, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeTestChat(t, ArchivePath(dir, "2024.01.02.00.00.00-b"),
+		Message{Role: "user", Content: "first"}, Message{Role: "assistant", Content: "answer"}, Message{Role: "user", Content: "last"})
+	writeTestChat(t, ArchivePath(dir, "2024.03.01.00.00.00-c"), Message{Role: "user", Content: "newest"})
+	writeTestChat(t, ArchivePath(dir, "2023.12.31.00.00.00-a"), Message{Role: "user", Content: "oldest"})
+	writeTestChat(t, ArchivePath(dir, "2024.02.01.00.00.00-empty"), Message{Role: "assistant", Content: "no question"})
+	if err := os.WriteFile(SummaryPath(ArchivePath(dir, "2024.02.01.00.00.00-empty")), []byte("summary"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ArchivePath(dir, "2024.02.02.00.00.00-broken"), []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	archives, err := ListArchives(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, a := range archives {
+		ids = append(ids, a.ID)
+	}
+	want := []string{"2024.03.01.00.00.00-c", "2024.01.02.00.00.00-b", "2023.12.31.00.00.00-a"}
+	if len(ids) != len(want) {
+		t.Fatalf("archives = %q, want %q", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("archives = %q, want %q", ids, want)
+		}
+	}
+	if b := archives[1]; b.FirstQuestion != "first" || b.LastQuestion != "last" || b.Messages != 3 {
+		t.Errorf("archive = %+v", b)
+	}
+	if want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local); !archives[1].Created.Equal(want) {
+		t.Errorf("created = %v, want %v", archives[1].Created, want)
+	}
+
+	if exists(ArchivePath(dir, "2024.02.01.00.00.00-empty")) {
+		t.Error("archive without a question left in the archive folder")
+	}
+	for _, name := range []string{"2024.02.01.00.00.00-empty.json", "2024.02.01.00.00.00-empty.summary"} {
+		if !exists(filepath.Join(trash, name)) {
+			t.Errorf("%s not moved to the Trash", name)
+		}
+	}
+	if !exists(ArchivePath(dir, "2024.02.02.00.00.00-broken")) {
+		t.Error("unreadable archive removed")
+	}
+}
+
+func TestTrashFilesNumbersTakenNames(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	trash := filepath.Join(home, ".Trash")
+	if err := os.Mkdir(trash, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, "chat.json")
+		if err := os.WriteFile(path, []byte{byte('0' + i)}, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := TrashFiles(path, filepath.Join(dir, "missing.json")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range []string{"chat.json", "chat 2.json", "chat 3.json"} {
+		if data, err := os.ReadFile(filepath.Join(trash, name)); err != nil || string(data) != string(rune('0'+i)) {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go/option"
+)
+
+// DefaultAzureAPIVersion is the generally available Azure OpenAI API version
+// used when azure_api_version is not set.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// AzureOptions sends OpenAI requests to an Azure OpenAI resource instead,
+// which addresses models by deployment name, requires an api-version and
+// takes either an api-key header or a Microsoft Entra ID token.
+type AzureOptions struct {
+	Endpoint   string
+	APIVersion string
+	APIKey     string
+	Token      string
+	// Deployments maps model names to the deployments serving them; a model
+	// missing here is assumed to be deployed under its own name.
+	Deployments map[string]string
+}
+
+// Azure routes that name a model in their JSON body and are served per
+// deployment.
+var azureDeploymentRoutes = map[string]bool{
+	"chat/completions":   true,
+	"completions":        true,
+	"embeddings":         true,
+	"images/generations": true,
+}
+
+// LoadAzureOptions reads the Azure settings, returning nil when azure_endpoint
+// is not set. azure_deployments is a JSON object such as
+// {"gpt-4o": "chat-prod"}.
+func LoadAzureOptions() (*AzureOptions, error) {
+	endpoint := strings.TrimSpace(os.Getenv("azure_endpoint"))
+	if endpoint == "" {
+		return nil, nil
+	}
+	azure := &AzureOptions{
+		Endpoint:   endpoint,
+		APIVersion: os.Getenv("azure_api_version"),
+		APIKey:     os.Getenv("azure_api_key"),
+		Token:      os.Getenv("azure_ad_token"),
+	}
+	if azure.APIVersion == "" {
+		azure.APIVersion = DefaultAzureAPIVersion
+	}
+	if raw := strings.TrimSpace(os.Getenv("azure_deployments")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &azure.Deployments); err != nil {
+			return nil, fmt.Errorf("azure_deployments: %w", err)
+		}
+	}
+	return azure, nil
+}
+
+// Deployment returns the deployment serving model.
+func (a *AzureOptions) Deployment(model string) string {
+	if deployment := a.Deployments[model]; deployment != "" {
+		return deployment
+	}
+	return model
+}
+
+// requestOptions points the client at the resource's /openai/ path and moves
+// each request under the deployment of the model in its body.
+func (a *AzureOptions) requestOptions() ([]option.RequestOption, error) {
+	if a.APIKey == "" && a.Token == "" {
+		return nil, fmt.Errorf("azure_api_key or azure_ad_token not set")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(strings.TrimRight(a.Endpoint, "/"), "/openai"))
+	if err != nil {
+		return nil, fmt.Errorf("azure_endpoint: %w", err)
+	}
+	basePath := endpoint.Path + "/openai/"
+	endpoint.Path = basePath
+
+	opts := []option.RequestOption{
+		option.WithBaseURL(endpoint.String()),
+		option.WithQueryAdd("api-version", a.APIVersion),
+		option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+			route := strings.TrimPrefix(req.URL.Path, basePath)
+			if !azureDeploymentRoutes[route] || req.Body == nil {
+				return next(req)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			var named struct {
+				Model string `json:"model"`
+			}
+			if err := json.Unmarshal(body, &named); err != nil {
+				return nil, err
+			}
+			if named.Model == "" {
+				return nil, fmt.Errorf("no model to find the Azure deployment for")
+			}
+			req.URL.Path = basePath + "deployments/" + a.Deployment(named.Model) + "/" + route
+			req.URL.RawPath = ""
+			return next(req)
+		}),
+	}
+	// An OPENAI_API_KEY in the environment would otherwise add its own
+	// Authorization header.
+	if a.Token != "" {
+		opts = append(opts, option.WithHeader("Authorization", "Bearer "+a.Token))
+	} else {
+		opts = append(opts, option.WithHeaderDel("Authorization"), option.WithHeader("Api-Key", a.APIKey))
+	}
+	return opts, nil
+}
@@ -0,0 +1,118 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budgets assume an answer of this many tokens, since its real length is
+// only known once it has been paid for.
+const EstimatedReplyTokens = 500
+
+// Budget caps spend in US dollars per calendar day and month. Zero means no
+// cap.
+type Budget struct {
+	Daily   float64
+	Monthly float64
+}
+
+// BudgetExceeded is returned when a request would take spend past a cap.
+type BudgetExceeded struct {
+	Kind     string
+	Period   UsagePeriod
+	Limit    float64
+	Spent    float64
+	Estimate float64
+}
+
+func (e *BudgetExceeded) Error() string {
+	name, when := "Daily", "today"
+	if e.Period == UsageMonthly {
+		name, when = "Monthly", "this month"
+	}
+	service := "chat"
+	if e.Kind == UsageImage {
+		service = "DALL·E"
+	}
+	return fmt.Sprintf("%s %s budget of %s would be exceeded: %s spent %s and this request would cost about %s. Set budget_override to 1 to send it anyway.",
+		name, service, formatCost(e.Limit), formatCost(e.Spent), when, formatCost(e.Estimate))
+}
+
+// BudgetUnpriced is returned when a cap is set but a request, or spend
+// already recorded, is for a model without a price, so the cap cannot be
+// enforced.
+type BudgetUnpriced struct {
+	Kind  string
+	Model string
+}
+
+func (e *BudgetUnpriced) Error() string {
+	service := "chat"
+	if e.Kind == UsageImage {
+		service = "DALL·E"
+	}
+	return fmt.Sprintf("The %s budget cannot be enforced: %s has no price. Add it to usage_prices, as {\"input\": …, \"output\": …} in dollars per million tokens or {} if it is free, or set budget_override to 1 to send it anyway.",
+		service, e.Model)
+}
+
+// Check refuses a request of the given kind costing about estimate when it
+// would take the day's or the month's spend on that kind past its cap.
+func (b Budget) Check(records []UsageRecord, prices Prices, kind string, estimate float64, now time.Time) error {
+	limits := []struct {
+		period UsagePeriod
+		limit  float64
+	}{{UsageDaily, b.Daily}, {UsageMonthly, b.Monthly}}
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+		spent, unpriced := Spent(records, prices, kind, l.period, now)
+		if unpriced != "" {
+			return &BudgetUnpriced{Kind: kind, Model: unpriced}
+		}
+		if spent+estimate > l.limit {
+			return &BudgetExceeded{Kind: kind, Period: l.period, Limit: l.limit, Spent: spent, Estimate: estimate}
+		}
+	}
+	return nil
+}
+
+// Spent totals the priced cost of kind's records in the day or month
+// containing now. unpriced names a model among them that has no price, whose
+// spend the total is missing.
+func Spent(records []UsageRecord, prices Prices, kind string, period UsagePeriod, now time.Time) (total float64, unpriced string) {
+	start := usagePeriodStart(now, period)
+	for _, rec := range records {
+		if rec.Kind != kind || rec.Time.Before(start) {
+			continue
+		}
+		cost, ok := prices.Cost(rec)
+		if !ok && unpriced == "" {
+			unpriced = rec.Model
+		}
+		total += cost
+	}
+	return total, unpriced
+}
+
+// CheckBudget loads the ledger and prices and checks a request against
+// budget. It does nothing when budget has no caps, and refuses a request for a
+// model without a price rather than count it as free.
+func CheckBudget(env *Env, budget Budget, rec UsageRecord) error {
+	if env.BudgetOverride || (budget.Daily <= 0 && budget.Monthly <= 0) {
+		return nil
+	}
+	prices, err := LoadPrices()
+	if err != nil {
+		return err
+	}
+	records, err := ReadUsage(env.UsageFile)
+	if err != nil {
+		return err
+	}
+	estimate, ok := prices.Cost(rec)
+	if !ok {
+		return &BudgetUnpriced{Kind: rec.Kind, Model: rec.Model}
+	}
+	return budget.Check(records, prices, rec.Kind, estimate, time.Now())
+}
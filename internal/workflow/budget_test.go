@@ -0,0 +1,98 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgetCheck(t *testing.T) {
+	now := time.Date(2025, 3, 15, 12, 0, 0, 0, time.Local)
+	prices := Prices{"gpt-4o": {Input: 2.5, Output: 10}, "llama3.1": {}}
+	chat := func(model string, when time.Time, prompt int64) UsageRecord {
+		return UsageRecord{Time: when, Kind: UsageChat, Model: model, PromptTokens: prompt}
+	}
+	tests := []struct {
+		name     string
+		budget   Budget
+		records  []UsageRecord
+		estimate float64
+		want     error
+	}{
+		{"no caps", Budget{}, []UsageRecord{chat("gpt-4o", now, 1e9)}, 1, nil},
+		{"under the daily cap", Budget{Daily: 1}, []UsageRecord{chat("gpt-4o", now, 200_000)}, 0.4, nil},
+		{"over the daily cap", Budget{Daily: 1}, []UsageRecord{chat("gpt-4o", now, 200_000)}, 0.6, &BudgetExceeded{}},
+		{"yesterday counts for the month only", Budget{Daily: 1, Monthly: 2}, []UsageRecord{chat("gpt-4o", now.AddDate(0, 0, -1), 600_000)}, 0.6, &BudgetExceeded{}},
+		{"last month not counted", Budget{Monthly: 1}, []UsageRecord{chat("gpt-4o", now.AddDate(0, -1, 0), 1e9)}, 0.5, nil},
+		{"images not counted against chat", Budget{Daily: 1}, []UsageRecord{{Time: now, Kind: UsageImage, Model: "mystery", Images: 1}}, 0.5, nil},
+		{"free model priced as such", Budget{Daily: 1}, []UsageRecord{chat("llama3.1", now, 1e9)}, 0.5, nil},
+		{"unpriced spend", Budget{Daily: 1}, []UsageRecord{chat("mystery", now, 10)}, 0.1, &BudgetUnpriced{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.budget.Check(tt.records, prices, UsageChat, tt.estimate, now)
+			switch want := tt.want.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("Check = %v, want nil", err)
+				}
+			case *BudgetExceeded:
+				if !errors.As(err, &want) {
+					t.Errorf("Check = %v, want the cap exceeded", err)
+				}
+			case *BudgetUnpriced:
+				if !errors.As(err, &want) || want.Model != "mystery" {
+					t.Errorf("Check = %v, want the unpriced model named", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBudgetRefusesUnpricedModels(t *testing.T) {
+	t.Setenv("usage_prices", "")
+	env := &Env{UsageFile: t.TempDir() + "/usage.jsonl"}
+	budget := Budget{Daily: 5}
+	for _, model := range []string{"claude-sonnet-4-5-20250929", "gemini-2.5-flash", "gpt-5.1-codex"} {
+		if err := CheckBudget(env, budget, UsageRecord{Kind: UsageChat, Model: model, PromptTokens: 1000}); err != nil {
+			t.Errorf("%s: %v, want it priced", model, err)
+		}
+	}
+	var unpriced *BudgetUnpriced
+	if err := CheckBudget(env, budget, UsageRecord{Kind: UsageChat, Model: "qwen3:8b", PromptTokens: 1000}); !errors.As(err, &unpriced) {
+		t.Errorf("unpriced model: %v, want it refused", err)
+	}
+
+	env.BudgetOverride = true
+	if err := CheckBudget(env, budget, UsageRecord{Kind: UsageChat, Model: "qwen3:8b"}); err != nil {
+		t.Errorf("override: %v", err)
+	}
+	env.BudgetOverride = false
+	t.Setenv("usage_prices", `{"qwen3": {}}`)
+	if err := CheckBudget(env, budget, UsageRecord{Kind: UsageChat, Model: "qwen3:8b"}); err != nil {
+		t.Errorf("model priced as free in usage_prices: %v", err)
+	}
+}
+
+func TestImageCost(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  UsageRecord
+		want float64
+		ok   bool
+	}{
+		{"dall-e-3 by size and quality", UsageRecord{Model: "dall-e-3", Images: 2, Quality: "hd", Size: "1024x1024"}, 0.16, true},
+		{"dall-e-2 by size", UsageRecord{Model: "dall-e-2", Images: 1, Quality: "standard", Size: "512x512"}, 0.018, true},
+		{"unknown size", UsageRecord{Model: "dall-e-3", Images: 1, Quality: "standard", Size: "64x64"}, 0, false},
+		{"gpt-image-1 estimate", UsageRecord{Model: "gpt-image-1", Images: 1, Quality: "high", Size: "1536x1024"}, 0.25, true},
+		{"gpt-image-1 billed by tokens", UsageRecord{Model: "gpt-image-1", Images: 1, Quality: "high", Size: "1536x1024", PromptTokens: 100_000, CompletionTokens: 10_000}, 0.9, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DefaultPrices.Cost(tt.rec)
+			if ok != tt.ok || got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("Cost = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
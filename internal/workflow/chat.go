@@ -11,6 +11,61 @@ func ResolveChatModel(gptModel, override string) string {
 	return gptModel
 }
 
+// IsReasoningModel reports whether model thinks before answering, which
+// makes the first words of an answer slow to arrive.
+func IsReasoningModel(model string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4"} {
+		if model == prefix || strings.HasPrefix(model, prefix+"-") {
+			return true
+		}
+	}
+	// GPT-5 and its point releases (gpt-5.1, gpt-5.2-pro) reason, except the
+	// chat variants ChatGPT uses.
+	rest, ok := strings.CutPrefix(model, "gpt-5")
+	if !ok {
+		return false
+	}
+	if rest != "" && rest[0] == '.' {
+		rest = strings.TrimLeft(rest[1:], "0123456789")
+	}
+	return rest == "" || strings.HasPrefix(rest, "-") && !strings.HasPrefix(rest, "-chat")
+}
+
+// AddVariant makes content the shown answer while keeping the previous ones.
+func (m *Message) AddVariant(content string) {
+	if len(m.Variants) == 0 {
+		m.Variants = []string{m.Content}
+	}
+	m.Variants = append(m.Variants, content)
+	m.Selected = len(m.Variants) - 1
+	m.Content = content
+}
+
+// CycleVariant shows the answer step places away, wrapping around. It
+// reports false when there is nothing to switch to.
+func (m *Message) CycleVariant(step int) bool {
+	n := len(m.Variants)
+	if n < 2 {
+		return false
+	}
+	m.Selected = ((m.Selected+step)%n + n) % n
+	m.Content = m.Variants[m.Selected]
+	// The answer stored on the server, and the reasoning behind it, may no
+	// longer be the one shown.
+	m.ResponseID = ""
+	m.Reasoning = ""
+	return true
+}
+
+func LastAssistant(messages []Message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "assistant" {
+			return i
+		}
+	}
+	return -1
+}
+
 func TrimContext(messages []Message, max int) []Message {
 	if max <= 0 || len(messages) <= max {
 		return messages
@@ -18,6 +73,61 @@ func TrimContext(messages []Message, max int) []Message {
 	return messages[len(messages)-max:]
 }
 
+// Older turns are only shortened to fit the budget when at least this many
+// tokens of them would survive; smaller fragments are dropped instead.
+const minTruncatedTokens = 32
+
+// TrimContextTokens keeps the newest messages whose estimated token count fits
+// in budget. The latest user turn and everything after it is always kept, and
+// the first older message that does not fit is cut down to its tail when a
+// meaningful part of it still fits. A budget the system prompt has already
+// used up leaves only that latest turn.
+func TrimContextTokens(messages []Message, enc Encoding, budget int) []Message {
+	if len(messages) == 0 {
+		return messages
+	}
+	start := len(messages) - 1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			start = i
+			break
+		}
+	}
+	used := tokensPerReply
+	for _, m := range messages[start:] {
+		used += MessageTokens(enc, m)
+	}
+
+	kept := append([]Message(nil), messages[start:]...)
+	for i := start - 1; i >= 0; i-- {
+		cost := MessageTokens(enc, messages[i])
+		if used+cost <= budget {
+			kept = append([]Message{messages[i]}, kept...)
+			used += cost
+			continue
+		}
+		remaining := budget - used - MessageTokens(enc, Message{Role: messages[i].Role})
+		if remaining >= minTruncatedTokens {
+			cut := messages[i]
+			cut.Content = "…" + TruncateTokens(enc, cut.Content, remaining-1)
+			kept = append([]Message{cut}, kept...)
+		}
+		break
+	}
+	return kept
+}
+
+// ShrinkContext cuts messages to about three quarters of their estimated size
+// for retrying a request the model found too long. It returns nil when
+// nothing older than the latest question is left to drop.
+func ShrinkContext(messages []Message, enc Encoding) []Message {
+	shorter := TrimContextTokens(messages, enc, ContextTokens(enc, "", messages)*3/4)
+	if len(shorter) == len(messages) && (len(shorter) == 0 || shorter[0].Content == messages[0].Content) {
+		return nil
+	}
+	return shorter
+}
+
 func BuildMessages(systemPrompt string, context []Message) []map[string]string {
 	var msgs []map[string]string
 	if systemPrompt != "" {
@@ -0,0 +1,32 @@
+package workflow
+
+import "testing"
+
+func TestIsReasoningModel(t *testing.T) {
+	tests := map[string]bool{
+		"o1":                  true,
+		"o1-mini":             true,
+		"o3":                  true,
+		"o3-pro":              true,
+		"o4-mini":             true,
+		"gpt-5":               true,
+		"gpt-5-mini":          true,
+		"gpt-5-nano":          true,
+		"gpt-5.1":             true,
+		"gpt-5.1-codex":       true,
+		"gpt-5.2":             true,
+		"gpt-5.2-pro":         true,
+		"gpt-5-chat-latest":   false,
+		"gpt-5.1-chat-latest": false,
+		"gpt-4o":              false,
+		"gpt-4.1":             false,
+		"o10":                 false,
+		"claude-sonnet-4-5":   false,
+		"":                    false,
+	}
+	for model, want := range tests {
+		if got := IsReasoningModel(model); got != want {
+			t.Errorf("IsReasoningModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultConversationID is used when conversation_id is unset. It keeps the
+// original chat.json, stream.txt and pid.txt locations.
+const DefaultConversationID = "default"
+
+const conversationTitleLength = 60
+
+var conversationIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type Conversation struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+type ConversationFiles struct {
+	Chat   string
+	Stream string
+	Socket string
+	PID    string
+}
+
+func ValidConversationID(id string) bool {
+	return conversationIDPattern.MatchString(id)
+}
+
+func ConversationPaths(dataDir, cacheDir, id string) (ConversationFiles, error) {
+	if id == "" || id == DefaultConversationID {
+		return ConversationFiles{
+			Chat:   filepath.Join(dataDir, "chat.json"),
+			Stream: filepath.Join(cacheDir, "stream.txt"),
+			Socket: socketPath(filepath.Join(cacheDir, "stream.sock")),
+			PID:    filepath.Join(cacheDir, "pid.txt"),
+		}, nil
+	}
+	if !ValidConversationID(id) {
+		return ConversationFiles{}, fmt.Errorf("invalid conversation_id %q", id)
+	}
+	return ConversationFiles{
+		Chat:   filepath.Join(dataDir, "conversations", id+".json"),
+		Stream: filepath.Join(cacheDir, "stream-"+id+".txt"),
+		Socket: socketPath(filepath.Join(cacheDir, "stream-"+id+".sock")),
+		PID:    filepath.Join(cacheDir, "pid-"+id+".txt"),
+	}, nil
+}
+
+func ConversationsPath(dataDir string) string {
+	return filepath.Join(dataDir, "conversations.json")
+}
+
+func ReadConversations(path string) ([]Conversation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []Conversation{}, nil
+		}
+		return nil, err
+	}
+	decoded, err := maybeDecrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return []Conversation{}, nil
+	}
+	var conversations []Conversation
+	if err := json.Unmarshal(decoded, &conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+func WriteConversations(path string, conversations []Conversation) error {
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].Updated.After(conversations[j].Updated)
+	})
+	data, err := json.Marshal(conversations)
+	if err != nil {
+		return err
+	}
+	payload, err := maybeEncrypt(data)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(path, payload)
+}
+
+// updateConversations reads the registry at path, applies update and writes
+// the result back while holding the registry's lock, as UpdateChatTree does
+// for a chat.
+func updateConversations(path string, update func([]Conversation) []Conversation) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	conversations, err := ReadConversations(path)
+	if err != nil {
+		return err
+	}
+	return WriteConversations(path, update(conversations))
+}
+
+// TouchConversation records activity on id, creating the registry entry when
+// needed. An empty title is filled from firstQuestion.
+func TouchConversation(path, id, firstQuestion string, now time.Time) error {
+	if id == "" {
+		id = DefaultConversationID
+	}
+	return updateConversations(path, func(conversations []Conversation) []Conversation {
+		idx := -1
+		for i, c := range conversations {
+			if c.ID == id {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			conversations = append(conversations, Conversation{ID: id, Created: now})
+			idx = len(conversations) - 1
+		}
+		if conversations[idx].Title == "" {
+			conversations[idx].Title = ConversationTitle(firstQuestion)
+		}
+		conversations[idx].Updated = now
+		return conversations
+	})
+}
+
+func NewConversation(path, title string, now time.Time) (Conversation, error) {
+	conv := Conversation{
+		ID:      RandomUID(),
+		Title:   ConversationTitle(title),
+		Created: now,
+		Updated: now,
+	}
+	return conv, updateConversations(path, func(conversations []Conversation) []Conversation {
+		return append(conversations, conv)
+	})
+}
+
+func ConversationTitle(text string) string {
+	line := strings.TrimSpace(text)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	runes := []rune(line)
+	if len(runes) > conversationTitleLength {
+		return strings.TrimSpace(string(runes[:conversationTitleLength])) + "…"
+	}
+	return line
+}
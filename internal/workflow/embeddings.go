@@ -0,0 +1,426 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Each embedding request carries at most this many message pairs, and each
+// pair is cut to stay well inside the embedding models' input limit.
+const (
+	embeddingBatchSize = 32
+	embeddingMaxTokens = 6000
+)
+
+// EmbedFunc turns texts into vectors, one per text and in the same order.
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// EmbeddingIndex is the manifest of the vectors stored for archived chats:
+// one entry per chat, while the vectors of each chat's question/answer pairs
+// live in a binary file of their own next to it. Batches are appended to that
+// file as they arrive, so an interrupted run picks up where it stopped and
+// embedding a chat never rewrites the others. A doc is Complete once all its
+// pairs are stored.
+type EmbeddingIndex struct {
+	Version int                    `json:"version"`
+	Model   string                 `json:"model"`
+	Docs    map[string]EmbeddedDoc `json:"docs"`
+
+	dir string
+}
+
+type EmbeddedDoc struct {
+	ModTime  time.Time `json:"mod_time"`
+	Size     int64     `json:"size"`
+	Title    string    `json:"title"`
+	Complete bool      `json:"complete"`
+}
+
+type EmbeddedPair struct {
+	Msg    int
+	Vector []float32
+}
+
+// MessagePair is a user question together with the answer that followed it.
+type MessagePair struct {
+	Msg  int
+	Text string
+}
+
+type SemanticHit struct {
+	ID    string
+	Title string
+	Msg   int
+	Score float64
+}
+
+type EmbeddingProgress struct {
+	Indexed int
+	Total   int
+}
+
+// embeddingIndexVersion changes whenever stored vectors stop being readable,
+// which discards the index and embeds the archive again.
+const embeddingIndexVersion = 2
+
+func EmbeddingIndexPath(dataDir string) string {
+	return filepath.Join(IndexDir(dataDir), "embeddings.json")
+}
+
+// embeddingVectorDir holds the vector files of the index at indexPath.
+func embeddingVectorDir(indexPath string) string {
+	return strings.TrimSuffix(indexPath, ".json")
+}
+
+func (idx *EmbeddingIndex) vectorPath(id string) string {
+	return filepath.Join(idx.dir, id+".vec")
+}
+
+func ReadEmbeddingIndex(path, model string) (*EmbeddingIndex, error) {
+	idx := &EmbeddingIndex{Version: embeddingIndexVersion, Model: model, Docs: map[string]EmbeddedDoc{}, dir: embeddingVectorDir(path)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	decoded, err := maybeDecrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return idx, nil
+	}
+	var stored EmbeddingIndex
+	if err := json.Unmarshal(decoded, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Version != embeddingIndexVersion || stored.Model != model || stored.Docs == nil {
+		return idx, nil
+	}
+	stored.dir = idx.dir
+	return &stored, nil
+}
+
+func WriteEmbeddingIndex(path string, idx *EmbeddingIndex) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	payload, err := maybeEncrypt(data)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(path, payload)
+}
+
+// A vector file is a sequence of frames, one per embedding batch: a
+// little-endian uint32 length followed by that many bytes, encrypted like the
+// chats when storage_secret is set. Decrypted, a frame holds one record per
+// pair: the int32 index of the question, the uint32 number of dimensions and
+// the vector as float32s.
+
+// appendVectors adds pairs to the vector file at path as one frame.
+func appendVectors(path string, pairs []EmbeddedPair) error {
+	var raw bytes.Buffer
+	for _, p := range pairs {
+		binary.Write(&raw, binary.LittleEndian, int32(p.Msg))
+		binary.Write(&raw, binary.LittleEndian, uint32(len(p.Vector)))
+		binary.Write(&raw, binary.LittleEndian, p.Vector)
+	}
+	payload, err := maybeEncrypt(raw.Bytes())
+	if err != nil {
+		return err
+	}
+	frame := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(frame)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readVectors returns the pairs stored at path and the length of the file up
+// to the last complete frame; a frame cut short by an interrupted write is
+// left out.
+func readVectors(path string) ([]EmbeddedPair, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	var pairs []EmbeddedPair
+	var valid int64
+	for len(data) >= 4 {
+		n := int(binary.LittleEndian.Uint32(data))
+		if len(data)-4 < n {
+			break
+		}
+		raw, err := maybeDecrypt(data[4 : 4+n])
+		if err != nil {
+			return nil, 0, err
+		}
+		for len(raw) > 0 {
+			if len(raw) < 8 {
+				return nil, 0, errors.New("vector file corrupt")
+			}
+			msg := int32(binary.LittleEndian.Uint32(raw))
+			dims := int(binary.LittleEndian.Uint32(raw[4:]))
+			raw = raw[8:]
+			if len(raw) < dims*4 {
+				return nil, 0, errors.New("vector file corrupt")
+			}
+			vector := make([]float32, dims)
+			for i := range vector {
+				vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+			}
+			raw = raw[dims*4:]
+			pairs = append(pairs, EmbeddedPair{Msg: int(msg), Vector: vector})
+		}
+		data = data[4+n:]
+		valid += int64(4 + n)
+	}
+	return pairs, valid, nil
+}
+
+func MessagePairs(msgs []Message) []MessagePair {
+	var pairs []MessagePair
+	for i, m := range msgs {
+		if m.Role != "user" || strings.TrimSpace(m.Content) == "" {
+			continue
+		}
+		text := m.Content
+		if i+1 < len(msgs) && msgs[i+1].Role == "assistant" {
+			text += "\n\n" + msgs[i+1].Content
+		}
+		if CountTokens(EncodingCL100K, text) > embeddingMaxTokens {
+			runes := []rune(text)
+			for len(runes) > 0 && CountTokens(EncodingCL100K, string(runes)) > embeddingMaxTokens {
+				runes = runes[:len(runes)*3/4]
+			}
+			text = string(runes)
+		}
+		pairs = append(pairs, MessagePair{Msg: i, Text: text})
+	}
+	return pairs
+}
+
+// UpdateEmbeddingIndex embeds archives that are new, changed or unfinished,
+// storing each batch as it arrives so the work survives interruption. It stops
+// early once deadline passes and reports how many archives are fully indexed.
+// The index is locked meanwhile, so concurrent searches wait rather than embed
+// the same chats twice.
+func UpdateEmbeddingIndex(ctx context.Context, indexPath, archiveDir, model string, embed EmbedFunc, deadline time.Time) (*EmbeddingIndex, EmbeddingProgress, error) {
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
+		return nil, EmbeddingProgress{}, err
+	}
+	unlock, err := lockFile(indexPath)
+	if err != nil {
+		return nil, EmbeddingProgress{}, err
+	}
+	defer unlock()
+	idx, err := ReadEmbeddingIndex(indexPath, model)
+	if err != nil {
+		return nil, EmbeddingProgress{}, err
+	}
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, EmbeddingProgress{}, err
+	}
+
+	seen := map[string]bool{}
+	var pending []fs.DirEntry
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		seen[id] = true
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		doc, ok := idx.Docs[id]
+		if ok && doc.Complete && doc.Size == info.Size() && doc.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+		pending = append(pending, entry)
+	}
+
+	// Vector files without a doc are left from chats that were deleted or
+	// from an index that was discarded.
+	if files, err := os.ReadDir(idx.dir); err == nil {
+		for _, f := range files {
+			id := strings.TrimSuffix(f.Name(), ".vec")
+			if _, ok := idx.Docs[id]; !ok || !seen[id] {
+				RemoveFiles(filepath.Join(idx.dir, f.Name()))
+			}
+		}
+	}
+	removed := false
+	for id := range idx.Docs {
+		if !seen[id] {
+			delete(idx.Docs, id)
+			removed = true
+		}
+	}
+	if removed {
+		if err := WriteEmbeddingIndex(indexPath, idx); err != nil {
+			return nil, EmbeddingProgress{}, err
+		}
+	}
+
+	progress := EmbeddingProgress{Total: len(seen), Indexed: len(seen) - len(pending)}
+	for _, entry := range pending {
+		if time.Now().After(deadline) {
+			break
+		}
+		if err := embedArchive(ctx, indexPath, archiveDir, entry, idx, embed, deadline); err != nil {
+			return idx, progress, err
+		}
+		if idx.Docs[strings.TrimSuffix(entry.Name(), ".json")].Complete {
+			progress.Indexed++
+		}
+	}
+	return idx, progress, nil
+}
+
+func embedArchive(ctx context.Context, indexPath, archiveDir string, entry fs.DirEntry, idx *EmbeddingIndex, embed EmbedFunc, deadline time.Time) error {
+	id := strings.TrimSuffix(entry.Name(), ".json")
+	info, err := entry.Info()
+	if err != nil {
+		return nil
+	}
+	msgs, err := ReadChat(filepath.Join(archiveDir, entry.Name()))
+	if err != nil {
+		return nil
+	}
+	pairs := MessagePairs(msgs)
+	vectorPath := idx.vectorPath(id)
+
+	doc, ok := idx.Docs[id]
+	done := 0
+	if ok && doc.Size == info.Size() && doc.ModTime.Equal(info.ModTime()) {
+		stored, valid, err := readVectors(vectorPath)
+		if err == nil {
+			done = len(stored)
+			err = os.Truncate(vectorPath, valid)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			done = 0
+			RemoveFiles(vectorPath)
+		}
+	} else {
+		doc = EmbeddedDoc{ModTime: info.ModTime(), Size: info.Size()}
+		if len(pairs) > 0 {
+			doc.Title = msgs[pairs[0].Msg].Content
+		}
+		RemoveFiles(vectorPath)
+		idx.Docs[id] = doc
+		if err := WriteEmbeddingIndex(indexPath, idx); err != nil {
+			return err
+		}
+	}
+
+	for done < len(pairs) {
+		if time.Now().After(deadline) {
+			break
+		}
+		batch := pairs[done:min(len(pairs), done+embeddingBatchSize)]
+		texts := make([]string, len(batch))
+		for i, p := range batch {
+			texts[i] = p.Text
+		}
+		vectors, err := embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		if len(vectors) != len(batch) {
+			return errors.New("embedding response does not match request")
+		}
+		embedded := make([]EmbeddedPair, len(batch))
+		for i, p := range batch {
+			embedded[i] = EmbeddedPair{Msg: p.Msg, Vector: vectors[i]}
+		}
+		if err := appendVectors(vectorPath, embedded); err != nil {
+			return err
+		}
+		done += len(batch)
+	}
+
+	if done >= len(pairs) {
+		doc.Complete = true
+		idx.Docs[id] = doc
+		return WriteEmbeddingIndex(indexPath, idx)
+	}
+	return nil
+}
+
+// Nearest ranks archived chats by the cosine similarity of their closest
+// question/answer pair to query. Only the vector files are read; chats whose
+// vectors cannot be read are left out.
+func (idx *EmbeddingIndex) Nearest(query []float32, limit int) []SemanticHit {
+	var hits []SemanticHit
+	for id, doc := range idx.Docs {
+		pairs, _, err := readVectors(idx.vectorPath(id))
+		if err != nil || len(pairs) == 0 {
+			continue
+		}
+		best := SemanticHit{ID: id, Title: doc.Title, Score: -1}
+		for _, pair := range pairs {
+			if score := CosineSimilarity(query, pair.Vector); score > best.Score {
+				best.Score = score
+				best.Msg = pair.Msg
+			}
+		}
+		hits = append(hits, best)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,194 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEmbed gives every text a vector pointing at the first letter of its
+// question and records each batch it was asked for.
+type fakeEmbed struct {
+	batches [][]string
+	failAt  int
+}
+
+func (f *fakeEmbed) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if f.failAt > 0 && len(f.batches)+1 == f.failAt {
+		f.failAt = 0
+		return nil, errors.New("connection reset")
+	}
+	f.batches = append(f.batches, texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, 3)
+		vector[int(text[0]-'a')%3] = 1
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func (f *fakeEmbed) texts() int {
+	n := 0
+	for _, b := range f.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func writeTestPairs(t *testing.T, path string, questions ...string) {
+	t.Helper()
+	var msgs []Message
+	for _, q := range questions {
+		msgs = append(msgs, Message{Role: "user", Content: q}, Message{Role: "assistant", Content: "answer"})
+	}
+	writeTestChat(t, path, msgs...)
+}
+
+func TestUpdateEmbeddingIndex(t *testing.T) {
+	for _, secret := range []string{"", "hunter2"} {
+		t.Run(fmt.Sprintf("secret %q", secret), func(t *testing.T) {
+			t.Setenv(encryptionEnvKey, secret)
+			dir := t.TempDir()
+			archiveDir := ArchiveDir(dir)
+			indexPath := EmbeddingIndexPath(dir)
+			long := make([]string, embeddingBatchSize+8)
+			for i := range long {
+				long[i] = fmt.Sprintf("b question %d", i)
+			}
+			writeTestPairs(t, ArchivePath(archiveDir, "2024.01.01.00.00.00-long"), long...)
+			writeTestPairs(t, ArchivePath(archiveDir, "2024.01.02.00.00.00-short"), "a question")
+			deadline := time.Now().Add(time.Minute)
+
+			// The second batch of the long chat fails; what came before it is kept.
+			fake := &fakeEmbed{failAt: 2}
+			if _, _, err := UpdateEmbeddingIndex(context.Background(), indexPath, archiveDir, "model", fake.embed, deadline); err == nil {
+				t.Fatal("interrupted run reported no error")
+			}
+			idx, progress, err := UpdateEmbeddingIndex(context.Background(), indexPath, archiveDir, "model", fake.embed, deadline)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if progress != (EmbeddingProgress{Indexed: 2, Total: 2}) {
+				t.Errorf("progress = %+v", progress)
+			}
+			if fake.texts() != len(long)+1 {
+				t.Errorf("embedded %d texts, want each of the %d pairs once", fake.texts(), len(long)+1)
+			}
+
+			hits := idx.Nearest([]float32{1, 0, 0}, 1)
+			if len(hits) != 1 || hits[0].ID != "2024.01.02.00.00.00-short" || hits[0].Title != "a question" || hits[0].Score < 0.99 {
+				t.Errorf("hits = %+v", hits)
+			}
+			if hits := idx.Nearest([]float32{0, 1, 0}, 0); len(hits) != 2 || hits[0].ID != "2024.01.01.00.00.00-long" {
+				t.Errorf("hits = %+v", hits)
+			}
+
+			// The manifest holds no vectors, and another run has nothing to do.
+			manifest, err := os.ReadFile(indexPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest) > 1024 {
+				t.Errorf("manifest is %d bytes, want the vectors kept out of it", len(manifest))
+			}
+			fake.batches = nil
+			if _, _, err := UpdateEmbeddingIndex(context.Background(), indexPath, archiveDir, "model", fake.embed, deadline); err != nil || fake.texts() != 0 {
+				t.Errorf("indexed run embedded %d texts, %v", fake.texts(), err)
+			}
+		})
+	}
+}
+
+func TestUpdateEmbeddingIndexDropsStaleVectors(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	dir := t.TempDir()
+	archiveDir := ArchiveDir(dir)
+	indexPath := EmbeddingIndexPath(dir)
+	gone := ArchivePath(archiveDir, "2024.01.01.00.00.00-gone")
+	writeTestPairs(t, gone, "a question")
+	writeTestPairs(t, ArchivePath(archiveDir, "2024.01.02.00.00.00-kept"), "b question")
+	deadline := time.Now().Add(time.Minute)
+	fake := &fakeEmbed{}
+	if _, _, err := UpdateEmbeddingIndex(context.Background(), indexPath, archiveDir, "model", fake.embed, deadline); err != nil {
+		t.Fatal(err)
+	}
+	vectorDir := embeddingVectorDir(indexPath)
+	if files, _ := os.ReadDir(vectorDir); len(files) != 2 {
+		t.Fatalf("%d vector files, want one per chat", len(files))
+	}
+
+	os.Remove(gone)
+	idx, _, err := UpdateEmbeddingIndex(context.Background(), indexPath, archiveDir, "model", fake.embed, deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Docs["2024.01.01.00.00.00-gone"]; ok || exists(filepath.Join(vectorDir, "2024.01.01.00.00.00-gone.vec")) {
+		t.Error("vectors of a deleted chat kept")
+	}
+
+	// Another model's vectors are discarded along with its manifest.
+	fake.batches = nil
+	idx, _, err = UpdateEmbeddingIndex(context.Background(), indexPath, archiveDir, "other-model", fake.embed, deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.texts() != 1 || idx.Model != "other-model" {
+		t.Errorf("embedded %d texts for %s, want the chat again", fake.texts(), idx.Model)
+	}
+	if pairs, _, err := readVectors(idx.vectorPath("2024.01.02.00.00.00-kept")); err != nil || len(pairs) != 1 {
+		t.Errorf("vectors = %+v, %v; want only the new model's", pairs, err)
+	}
+}
+
+func TestReadVectorsSkipsCutFrame(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	path := filepath.Join(t.TempDir(), "chat.vec")
+	first := []EmbeddedPair{{Msg: 0, Vector: []float32{1, 2}}, {Msg: 2, Vector: []float32{-0.5, 3}}}
+	if err := appendVectors(path, first); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(path)
+	if err := appendVectors(path, []EmbeddedPair{{Msg: 4, Vector: []float32{7, 8}}}); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if err := os.Truncate(path, after.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	pairs, valid, err := readVectors(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid != before.Size() {
+		t.Errorf("valid length = %d, want %d", valid, before.Size())
+	}
+	if fmt.Sprint(pairs) != fmt.Sprint(first) {
+		t.Errorf("pairs = %v, want %v", pairs, first)
+	}
+}
+
+func TestReadEmbeddingIndexDiscardsOldFormat(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	path := filepath.Join(t.TempDir(), "embeddings.json")
+	old := `{"model":"model","docs":{"2024.01.01.00.00.00-a":{"complete":true,"pairs":[{"msg":0,"vector":[1,0]}]}}}`
+	if err := os.WriteFile(path, []byte(old), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadEmbeddingIndex(path, "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Docs) != 0 || idx.Version != embeddingIndexVersion {
+		t.Errorf("index = %+v, want an empty one to embed again", idx)
+	}
+	if !strings.HasSuffix(idx.vectorPath("x"), filepath.Join("embeddings", "x.vec")) {
+		t.Errorf("vector path = %s", idx.vectorPath("x"))
+	}
+}
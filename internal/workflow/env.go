@@ -3,8 +3,9 @@ package workflow
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Env struct {
@@ -14,14 +15,42 @@ type Env struct {
 	OrgID             string
 	ChatAPIEndpoint   string
 	DalleAPIEndpoint  string
+	Azure             *AzureOptions
+	ChatProvider      string
+	ChatAPI           string
+	ServerState       bool
+	ReasoningEffort   string
+	MaxTokens         int
+	ShowReasoning     bool
+	Anthropic         ProviderConfig
+	Gemini            ProviderConfig
+	Ollama            ProviderConfig
+	Routes            map[string]ModelRoute
+	Fallbacks         []string
 	GPTModel          string
 	ChatModelOverride string
 	SystemPrompt      string
 	MaxContext        int
+	MaxContextTokens  int
+	SummarizeContext  bool
+	SummaryModel      string
+	EmbeddingModel    string
 	TimeoutSeconds    int
+	ConnectTimeout    time.Duration
+	FirstTokenTimeout time.Duration
+	ContinueRounds    int
+	ConversationID    string
+	ConversationsFile string
 	StreamFile        string
+	SocketFile        string
 	PIDFile           string
 	ChatFile          string
+	SummaryFile       string
+	UsageFile         string
+	ChatBudget        Budget
+	DalleBudget       Budget
+	BudgetOverride    bool
+	Retry             RetryPolicy
 }
 
 func LoadEnv() (*Env, error) {
@@ -38,7 +67,21 @@ func LoadEnv() (*Env, error) {
 	}
 
 	maxContext := readIntEnv("max_context", 4)
+	maxContextTokens := readIntEnv("max_context_tokens", 0)
 	timeout := readIntEnv("timeout_seconds", 20)
+	embeddingModel := os.Getenv("embedding_model")
+	if embeddingModel == "" {
+		embeddingModel = "text-embedding-3-small"
+	}
+
+	azure, err := LoadAzureOptions()
+	if err != nil {
+		return nil, err
+	}
+	routes, err := LoadModelRoutes()
+	if err != nil {
+		return nil, err
+	}
 
 	env := &Env{
 		WorkflowDataDir:   dataDir,
@@ -47,15 +90,65 @@ func LoadEnv() (*Env, error) {
 		OrgID:             os.Getenv("openai_org_id"),
 		ChatAPIEndpoint:   os.Getenv("chatgpt_api_endpoint"),
 		DalleAPIEndpoint:  os.Getenv("dalle_api_endpoint"),
+		Azure:             azure,
+		ChatProvider:      strings.ToLower(os.Getenv("chat_provider")),
+		ChatAPI:           strings.ToLower(os.Getenv("chat_api")),
+		ServerState:       stringsEqualFold(os.Getenv("responses_server_state"), "1", "true", "yes"),
+		ReasoningEffort:   strings.ToLower(strings.TrimSpace(os.Getenv("reasoning_effort"))),
+		MaxTokens:         readIntEnv("max_tokens", 0),
+		ShowReasoning:     stringsEqualFold(os.Getenv("show_reasoning"), "1", "true", "yes"),
+		Routes:            routes,
+		Fallbacks:         splitList(os.Getenv("model_fallbacks")),
 		GPTModel:          os.Getenv("gpt_model"),
 		ChatModelOverride: os.Getenv("chatgpt_model_override"),
 		SystemPrompt:      os.Getenv("system_prompt"),
 		MaxContext:        maxContext,
+		MaxContextTokens:  maxContextTokens,
+		SummarizeContext:  stringsEqualFold(os.Getenv("summarize_context"), "1", "true", "yes"),
+		SummaryModel:      os.Getenv("summary_model"),
+		EmbeddingModel:    embeddingModel,
 		TimeoutSeconds:    timeout,
+		ConnectTimeout:    time.Duration(readIntEnv("connect_timeout_seconds", 10)) * time.Second,
+		FirstTokenTimeout: time.Duration(readIntEnv("first_token_timeout_seconds", 0)) * time.Second,
+		ContinueRounds:    readIntEnv("auto_continue_rounds", 0),
+		Anthropic: ProviderConfig{
+			APIKey:   os.Getenv("anthropic_api_key"),
+			Endpoint: os.Getenv("anthropic_api_endpoint"),
+		},
+		Gemini: ProviderConfig{
+			APIKey:   os.Getenv("gemini_api_key"),
+			Endpoint: os.Getenv("gemini_api_endpoint"),
+		},
+		Ollama: ProviderConfig{
+			Endpoint: os.Getenv("ollama_api_endpoint"),
+		},
+		ChatBudget: Budget{
+			Daily:   readFloatEnv("chat_daily_budget", 0),
+			Monthly: readFloatEnv("chat_monthly_budget", 0),
+		},
+		DalleBudget: Budget{
+			Daily:   readFloatEnv("dalle_daily_budget", 0),
+			Monthly: readFloatEnv("dalle_monthly_budget", 0),
+		},
+		BudgetOverride: stringsEqualFold(os.Getenv("budget_override"), "1", "true", "yes"),
+		Retry:          DefaultRetryPolicy,
 	}
-	env.StreamFile = filepath.Join(cacheDir, "stream.txt")
-	env.PIDFile = filepath.Join(cacheDir, "pid.txt")
-	env.ChatFile = filepath.Join(dataDir, "chat.json")
+	env.Retry.MaxAttempts = readIntEnv("max_retries", DefaultRetryPolicy.MaxAttempts-1) + 1
+	env.ConversationID = os.Getenv("conversation_id")
+	if env.ConversationID == "" {
+		env.ConversationID = DefaultConversationID
+	}
+	files, err := ConversationPaths(dataDir, cacheDir, env.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	env.ConversationsFile = ConversationsPath(dataDir)
+	env.StreamFile = files.Stream
+	env.SocketFile = files.Socket
+	env.PIDFile = files.PID
+	env.ChatFile = files.Chat
+	env.SummaryFile = SummaryPath(env.ChatFile)
+	env.UsageFile = UsagePath(dataDir)
 	return env, nil
 }
 
@@ -70,3 +163,49 @@ func readIntEnv(key string, fallback int) int {
 	}
 	return i
 }
+
+func readFloatEnv(key string, fallback float64) float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
+
+// Thinks reports whether model reasons before it answers, on its own or
+// because reasoning_effort turns on thinking at a provider whose model
+// supports it. model is named as in gpt_model, with its provider or alias.
+func (e *Env) Thinks(model string) bool {
+	_, provider, name := e.Route(model)
+	switch provider {
+	case ProviderAnthropic:
+		return anthropicThinkingBudget[e.ReasoningEffort] > 0 && anthropicCanThink(name)
+	case ProviderGemini:
+		return geminiCanThink(name)
+	case ProviderOllama:
+		return e.ReasoningEffort != "" && e.ReasoningEffort != "minimal"
+	}
+	return IsReasoningModel(name)
+}
+
+// IdleTimeout is how long a streamed answer may pause between chunks.
+func (e *Env) IdleTimeout() time.Duration {
+	return time.Duration(e.TimeoutSeconds) * time.Second
+}
+
+// FirstTokenTimeoutFor is how long to wait for the first chunk of an answer
+// from model. Models that think before they answer, as Thinks says, are given
+// longer unless first_token_timeout_seconds says otherwise.
+func (e *Env) FirstTokenTimeoutFor(model string) time.Duration {
+	if e.FirstTokenTimeout > 0 {
+		return e.FirstTokenTimeout
+	}
+	if e.Thinks(model) {
+		return 10 * time.Minute
+	}
+	return time.Minute
+}
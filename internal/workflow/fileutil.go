@@ -1,17 +1,33 @@
 package workflow
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+// Message is one chat turn. ID and Parent place it in the chat's ChatTree.
+// Regenerated answers keep every version in Variants with Selected pointing at
+// the one mirrored in Content. Created is a Unix timestamp; the model, token
+// usage and finish reason are recorded for answers.
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	ID               string   `json:"id,omitempty"`
+	Parent           string   `json:"parent,omitempty"`
+	Role             string   `json:"role"`
+	Content          string   `json:"content"`
+	Variants         []string `json:"variants,omitempty"`
+	Selected         int      `json:"selected,omitempty"`
+	Created          int64    `json:"created,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	PromptTokens     int64    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int64    `json:"completion_tokens,omitempty"`
+	FinishReason     string   `json:"finish_reason,omitempty"`
+	ResponseID       string   `json:"response_id,omitempty"`
+	Reasoning        string   `json:"reasoning,omitempty"`
 }
 
 func EnsureChatFile(path string) error {
@@ -23,61 +39,97 @@ func EnsureChatFile(path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
-	return WriteChat(path, []Message{})
-}
-
-func ReadChat(path string) ([]Message, error) {
-	data, err := os.ReadFile(path)
+	unlock, err := lockFile(path)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return []Message{}, nil
-		}
-		return nil, err
+		return err
 	}
-	if len(data) == 0 {
-		return []Message{}, nil
+	defer unlock()
+	// Another invocation may have created and written to it meanwhile.
+	if _, err := os.Stat(path); err == nil {
+		return nil
 	}
-	decoded, err := maybeDecrypt(data)
+	return WriteChatTree(path, &ChatTree{})
+}
+
+// ReadChat returns the active branch of the chat at path.
+func ReadChat(path string) ([]Message, error) {
+	tree, err := ReadChatTree(path)
 	if err != nil {
 		return nil, err
 	}
-	if len(decoded) == 0 {
-		return []Message{}, nil
-	}
-	var messages []Message
-	if err := json.Unmarshal(decoded, &messages); err != nil {
-		return nil, err
-	}
-	return messages, nil
+	return tree.Branch(), nil
 }
 
+// WriteChat stores msgs as the active branch, keeping the other branches.
 func WriteChat(path string, msgs []Message) error {
-	data, err := json.Marshal(msgs)
+	return UpdateChatTree(path, func(tree *ChatTree) error {
+		tree.SetBranch(msgs)
+		return nil
+	})
+}
+
+func AppendChat(path string, msg Message) error {
+	_, err := UpdateChat(path, func(msgs []Message) ([]Message, error) {
+		return append(msgs, msg), nil
+	})
+	return err
+}
+
+// atomicWrite replaces path with data through a uniquely named temporary file
+// that is synced before the rename, so concurrent writers never share a
+// temporary file and a crash leaves either the old contents or the new.
+func atomicWrite(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	payload, err := maybeEncrypt(data)
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
 	if err != nil {
-		return err
+		os.Remove(tmp)
 	}
-	return atomicWrite(path, payload)
+	return err
 }
 
-func AppendChat(path string, msg Message) error {
-	msgs, err := ReadChat(path)
+// TrashFiles moves paths that exist into the user's Trash, numbering names
+// that are already taken the way Finder does. Files are left where they are
+// when there is no Trash to move them to.
+func TrashFiles(paths ...string) error {
+	home, err := os.UserHomeDir()
 	if err != nil {
 		return err
 	}
-	msgs = append(msgs, msg)
-	return WriteChat(path, msgs)
-}
-
-func atomicWrite(path string, data []byte) error {
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+	trash := filepath.Join(home, ".Trash")
+	if _, err := os.Stat(trash); err != nil {
 		return err
 	}
-	return os.Rename(tmp, path)
+	for _, p := range paths {
+		if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		ext := filepath.Ext(p)
+		name := strings.TrimSuffix(filepath.Base(p), ext)
+		dest := filepath.Join(trash, name+ext)
+		for n := 2; ; n++ {
+			if _, err := os.Lstat(dest); errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			dest = filepath.Join(trash, fmt.Sprintf("%s %d%s", name, n, ext))
+		}
+		if err := os.Rename(p, dest); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func FileModified(path string) (time.Time, error) {
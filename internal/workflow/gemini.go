@@ -0,0 +1,209 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Thinking budgets for each reasoning_effort; 128 is the least every
+// thinking Gemini model accepts.
+var geminiThinkingBudget = map[string]int{
+	"minimal": 128,
+	"low":     1024,
+	"medium":  8192,
+	"high":    24576,
+}
+
+// geminiCanThink reports whether model thinks, as Gemini models from 2.5 on
+// do; earlier ones reject a thinking configuration.
+func geminiCanThink(model string) bool {
+	return !strings.HasPrefix(model, "gemini-1.") && !strings.HasPrefix(model, "gemini-2.0-")
+}
+
+// geminiProvider speaks the Gemini API's generateContent.
+type geminiProvider struct {
+	config ProviderConfig
+	client *http.Client
+}
+
+type geminiPart struct {
+	Text    string `json:"text"`
+	Thought bool   `json:"thought,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+func (p *geminiProvider) StreamChat(ctx context.Context, req ChatRequest) (ChatStream, error) {
+	type thinkingConfig struct {
+		ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+		IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	}
+	type generationConfig struct {
+		MaxOutputTokens int             `json:"maxOutputTokens,omitempty"`
+		ThinkingConfig  *thinkingConfig `json:"thinkingConfig,omitempty"`
+	}
+	body := struct {
+		SystemInstruction *geminiContent   `json:"systemInstruction,omitempty"`
+		Contents          []geminiContent  `json:"contents"`
+		GenerationConfig  generationConfig `json:"generationConfig"`
+	}{}
+	body.GenerationConfig.MaxOutputTokens = req.MaxTokens
+	budget, hasBudget := geminiThinkingBudget[req.ReasoningEffort]
+	if (hasBudget || req.Reasoning) && geminiCanThink(req.Model) {
+		body.GenerationConfig.ThinkingConfig = &thinkingConfig{IncludeThoughts: req.Reasoning}
+		if hasBudget {
+			body.GenerationConfig.ThinkingConfig.ThinkingBudget = &budget
+		}
+	}
+	if len(req.System) > 0 {
+		body.SystemInstruction = &geminiContent{}
+		for _, system := range req.System {
+			body.SystemInstruction.Parts = append(body.SystemInstruction.Parts, geminiPart{Text: system})
+		}
+	}
+	for _, m := range alternateTurns(req.Messages) {
+		role := "user"
+		if m.Role == "assistant" {
+			role = "model"
+		}
+		body.Contents = append(body.Contents, geminiContent{Role: role, Parts: []geminiPart{{Text: m.Content}}})
+	}
+
+	header := http.Header{}
+	header.Set("X-Goog-Api-Key", p.config.APIKey)
+	base := strings.TrimRight(p.config.Endpoint, "/")
+	if base == "" {
+		base = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	model := url.PathEscape(strings.TrimPrefix(req.Model, "models/"))
+	resp, err := postStream(ctx, p.client, base+"/models/"+model+":streamGenerateContent?alt=sse", header, body, func(httpErr *HTTPError, data []byte) {
+		var failure struct {
+			Error geminiError `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil {
+			failure.Error.describe(httpErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &geminiStream{body: resp.Body, events: newSSEReader(resp.Body)}, nil
+}
+
+type geminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// describe fills in httpErr from the error Gemini reported, with the codes
+// ClassifyError knows from OpenAI.
+func (e geminiError) describe(httpErr *HTTPError) {
+	httpErr.Message = e.Message
+	if httpErr.StatusCode == 0 {
+		httpErr.StatusCode = e.Code
+	}
+	message := strings.ToLower(e.Message)
+	switch {
+	case e.Status == "UNAUTHENTICATED" || strings.Contains(message, "api key not valid"):
+		httpErr.Code = "invalid_api_key"
+	case strings.Contains(message, "exceeds the maximum number of tokens"):
+		httpErr.Code = "context_length_exceeded"
+	case e.Status == "NOT_FOUND" && strings.Contains(message, "model"):
+		httpErr.Code = "model_not_found"
+	}
+}
+
+type geminiStream struct {
+	body      io.Closer
+	events    *sseReader
+	delta     string
+	reasoning string
+	result    ChatResult
+	err       error
+}
+
+func (s *geminiStream) Next() bool {
+	_, data, ok := s.events.next()
+	if !ok {
+		s.err = s.events.err()
+		return false
+	}
+	var chunk struct {
+		Candidates []struct {
+			Content      geminiContent `json:"content"`
+			FinishReason string        `json:"finishReason"`
+		} `json:"candidates"`
+		PromptFeedback struct {
+			BlockReason string `json:"blockReason"`
+		} `json:"promptFeedback"`
+		UsageMetadata struct {
+			PromptTokenCount     int64 `json:"promptTokenCount"`
+			CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+			ThoughtsTokenCount   int64 `json:"thoughtsTokenCount"`
+		} `json:"usageMetadata"`
+		ModelVersion string       `json:"modelVersion"`
+		Error        *geminiError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		s.err = err
+		return false
+	}
+	if chunk.Error != nil {
+		httpErr := &HTTPError{Status: chunk.Error.Status}
+		chunk.Error.describe(httpErr)
+		s.err = httpErr
+		return false
+	}
+
+	if chunk.ModelVersion != "" {
+		s.result.Model = chunk.ModelVersion
+	}
+	if usage := chunk.UsageMetadata; usage.PromptTokenCount > 0 {
+		s.result.PromptTokens = usage.PromptTokenCount
+		s.result.CompletionTokens = usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+	}
+	if chunk.PromptFeedback.BlockReason != "" {
+		s.result.FinishReason = "content_filter"
+	}
+	s.delta, s.reasoning = "", ""
+	if len(chunk.Candidates) > 0 {
+		candidate := chunk.Candidates[0]
+		for _, part := range candidate.Content.Parts {
+			if part.Thought {
+				s.reasoning += part.Text
+			} else {
+				s.delta += part.Text
+			}
+		}
+		if candidate.FinishReason != "" {
+			s.result.FinishReason = geminiFinishReason(candidate.FinishReason)
+		}
+	}
+	return true
+}
+
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	}
+	return strings.ToLower(reason)
+}
+
+func (s *geminiStream) Delta() string      { return s.delta }
+func (s *geminiStream) Reasoning() string  { return s.reasoning }
+func (s *geminiStream) Err() error         { return s.err }
+func (s *geminiStream) Result() ChatResult { return s.result }
+func (s *geminiStream) Close() error       { return s.body.Close() }
@@ -0,0 +1,139 @@
+package workflow
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGeminiStream(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Weighing it up.","thought":true}]}}],"modelVersion":"gemini-2.5-pro"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"So, ","thought":true},{"text":"Hel"}]}}],"modelVersion":"gemini-2.5-pro"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"MAX_TOKENS"}],"usageMetadata":{"promptTokenCount":11,"candidatesTokenCount":5,"thoughtsTokenCount":20},"modelVersion":"gemini-2.5-pro"}`,
+	))
+	provider := &geminiProvider{config: ProviderConfig{APIKey: "key", Endpoint: api.URL + "/v1beta"}, client: http.DefaultClient}
+	got, err := readStream(t, provider, ChatRequest{
+		Model:           "gemini-2.5-pro",
+		System:          []string{"Be brief."},
+		Messages:        []Message{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}},
+		ReasoningEffort: "low",
+		Reasoning:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if got.answer() != "Hello" {
+		t.Errorf("answer = %q, want Hello", got.answer())
+	}
+	if len(got.reasoning) != 2 || got.reasoning[0]+got.reasoning[1] != "Weighing it up.So, " {
+		t.Errorf("reasoning = %q", got.reasoning)
+	}
+	want := ChatResult{Model: "gemini-2.5-pro", FinishReason: "length", PromptTokens: 11, CompletionTokens: 25}
+	if got.result != want {
+		t.Errorf("result = %+v, want %+v", got.result, want)
+	}
+
+	if api.request.URL.Path != "/v1beta/models/gemini-2.5-pro:streamGenerateContent" || api.request.URL.Query().Get("alt") != "sse" {
+		t.Errorf("url = %s", api.request.URL)
+	}
+	if api.request.Header.Get("X-Goog-Api-Key") != "key" {
+		t.Errorf("headers = %v", api.request.Header)
+	}
+	contents, _ := api.requestBody["contents"].([]any)
+	if len(contents) != 3 || contents[1].(map[string]any)["role"] != "model" {
+		t.Errorf("contents = %v, want answers sent as the model's turns", contents)
+	}
+	config, _ := api.requestBody["generationConfig"].(map[string]any)
+	thinking, _ := config["thinkingConfig"].(map[string]any)
+	if thinking["thinkingBudget"] != float64(1024) || thinking["includeThoughts"] != true {
+		t.Errorf("thinkingConfig = %v", config["thinkingConfig"])
+	}
+}
+
+func TestGeminiNoThinkingBefore25(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(`{"candidates":[{"content":{"parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`))
+	provider := &geminiProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+	if _, err := readStream(t, provider, ChatRequest{Model: "gemini-2.0-flash", ReasoningEffort: "high", Reasoning: true}); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := api.requestBody["generationConfig"].(map[string]any)
+	if _, ok := config["thinkingConfig"]; ok {
+		t.Errorf("thinkingConfig sent to gemini-2.0-flash: %v", config)
+	}
+}
+
+func TestGeminiBlockedPrompt(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(`{"promptFeedback":{"blockReason":"SAFETY"},"usageMetadata":{"promptTokenCount":4}}`))
+	provider := &geminiProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+	got, err := readStream(t, provider, ChatRequest{Model: "gemini-2.5-flash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.result.FinishReason != "content_filter" || got.answer() != "" {
+		t.Errorf("result = %+v, answer %q; want an empty filtered answer", got.result, got.answer())
+	}
+}
+
+func TestGeminiFinishReason(t *testing.T) {
+	tests := map[string]string{
+		"STOP":               "stop",
+		"MAX_TOKENS":         "length",
+		"SAFETY":             "content_filter",
+		"RECITATION":         "content_filter",
+		"PROHIBITED_CONTENT": "content_filter",
+		"OTHER":              "other",
+	}
+	for reason, want := range tests {
+		if got := geminiFinishReason(reason); got != want {
+			t.Errorf("geminiFinishReason(%q) = %q, want %q", reason, got, want)
+		}
+	}
+}
+
+func TestGeminiErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   ErrorKind
+	}{
+		{"bad key", 400, `{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}`, ErrorAuth},
+		{"unauthenticated", 401, `{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED"}}`, ErrorAuth},
+		{"too long", 400, `{"error":{"code":400,"message":"The input token count (2000000) exceeds the maximum number of tokens allowed (1048576).","status":"INVALID_ARGUMENT"}}`, ErrorContextLength},
+		{"unknown model", 404, `{"error":{"code":404,"message":"models/gemini-9 is not found for API version v1beta","status":"NOT_FOUND"}}`, ErrorModel},
+		{"rate limited", 429, `{"error":{"code":429,"message":"Resource has been exhausted","status":"RESOURCE_EXHAUSTED"}}`, ErrorRateLimit},
+		{"unavailable", 503, `{"error":{"code":503,"message":"The model is overloaded.","status":"UNAVAILABLE"}}`, ErrorServer},
+		{"bad request", 400, `{"error":{"code":400,"message":"Invalid JSON payload","status":"INVALID_ARGUMENT"}}`, ErrorInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, tt.status, "application/json", tt.body)
+			provider := &geminiProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+			_, err := readStream(t, provider, ChatRequest{Model: "gemini-2.5-flash"})
+			if got := errorKind(err); got != tt.want {
+				t.Errorf("error %v classified as %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiErrorInStream(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "text/event-stream", sse(
+		`{"candidates":[{"content":{"parts":[{"text":"Par"}]}}]}`,
+		`{"error":{"code":503,"message":"The model is overloaded.","status":"UNAVAILABLE"}}`,
+	))
+	provider := &geminiProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+	got, err := readStream(t, provider, ChatRequest{Model: "gemini-2.5-flash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.answer() != "Par" {
+		t.Errorf("answer = %q, want what came before the error", got.answer())
+	}
+	if kind := errorKind(got.err); kind != ErrorServer {
+		t.Errorf("error %v classified as %q, want %q", got.err, kind, ErrorServer)
+	}
+}
@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock for path, waiting while another
+// process holds it, and returns the function that releases it. The lock is
+// held on a separate path+".lock" file because atomicWrite replaces path
+// itself. A process that dies releases its lock with its open files.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// UpdateChatTree reads the chat at path, applies update and writes the result
+// back while holding the chat's lock, so that concurrent invocations cannot
+// lose each other's changes. Nothing is written when update fails.
+func UpdateChatTree(path string, update func(*ChatTree) error) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tree, err := ReadChatTree(path)
+	if err != nil {
+		return err
+	}
+	if err := update(tree); err != nil {
+		return err
+	}
+	return WriteChatTree(path, tree)
+}
+
+// UpdateChat is UpdateChatTree for the active branch. It returns the branch as
+// written.
+func UpdateChat(path string, update func([]Message) ([]Message, error)) ([]Message, error) {
+	var msgs []Message
+	err := UpdateChatTree(path, func(tree *ChatTree) error {
+		updated, err := update(tree.Branch())
+		if err != nil {
+			return err
+		}
+		tree.SetBranch(updated)
+		msgs = tree.Branch()
+		return nil
+	})
+	return msgs, err
+}
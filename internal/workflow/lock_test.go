@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	hammerPathEnv   = "GOCHAT_TEST_APPEND_PATH"
+	hammerWorkerEnv = "GOCHAT_TEST_APPEND_WORKER"
+	hammerWorkers   = 8
+	hammerProcesses = 8
+	hammerAppends   = 25
+)
+
+// TestHelperAppend is run in separate processes by TestConcurrentAppends and
+// does nothing otherwise.
+func TestHelperAppend(t *testing.T) {
+	path := os.Getenv(hammerPathEnv)
+	if path == "" {
+		return
+	}
+	worker := os.Getenv(hammerWorkerEnv)
+	for i := 0; i < hammerAppends; i++ {
+		if err := AppendChat(path, Message{Role: "user", Content: "process " + worker + " message " + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestConcurrentAppends hammers one chat with appends from goroutines and
+// from other processes at once; none may be lost and no temporary file may
+// be left behind.
+func TestConcurrentAppends(t *testing.T) {
+	t.Setenv(encryptionEnvKey, "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chat.json")
+	if err := EnsureChatFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, hammerWorkers+hammerProcesses)
+	for w := 0; w < hammerProcesses; w++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperAppend$")
+		cmd.Env = append(os.Environ(), hammerPathEnv+"="+path, hammerWorkerEnv+"="+strconv.Itoa(w))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("helper process: %v\n%s", err, out)
+			}
+		}()
+	}
+	for w := 0; w < hammerWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < hammerAppends; i++ {
+				msg := Message{Role: "user", Content: fmt.Sprintf("goroutine %d message %d", w, i)}
+				if err := AppendChat(path, msg); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	chat, err := ReadChat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (hammerWorkers + hammerProcesses) * hammerAppends; len(chat) != want {
+		t.Errorf("chat has %d messages, want %d", len(chat), want)
+	}
+	leftovers, err := filepath.Glob(filepath.Join(dir, "chat.json.*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
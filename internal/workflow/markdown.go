@@ -1,16 +1,29 @@
 package workflow
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 func MarkdownChat(messages []Message, ignoreLastInterrupted bool) string {
 	var builder strings.Builder
 	for i, msg := range messages {
 		switch msg.Role {
 		case "assistant":
+			if msg.Reasoning != "" {
+				builder.WriteString(MarkdownReasoning(msg.Reasoning, false))
+				builder.WriteString("\n\n")
+			}
 			if msg.Content != "" {
 				builder.WriteString(msg.Content)
 				builder.WriteString("\n\n")
 			}
+			if msg.FinishReason == FinishCancelled {
+				builder.WriteString("[Answer Stopped]\n\n")
+			}
+			if len(msg.Variants) > 1 {
+				builder.WriteString(fmt.Sprintf("*Answer %d of %d*\n\n", msg.Selected+1, len(msg.Variants)))
+			}
 		case "user":
 			builder.WriteString("# ⊙ You\n\n")
 			builder.WriteString(msg.Content)
@@ -24,3 +37,19 @@ func MarkdownChat(messages []Message, ignoreLastInterrupted bool) string {
 	}
 	return strings.TrimSpace(builder.String())
 }
+
+// MarkdownSummary renders the rolling summary of dropped turns as a collapsed
+// section shown above the chat.
+func MarkdownSummary(summary string) string {
+	return "<details>\n<summary>Earlier context</summary>\n\n" + strings.TrimSpace(summary) + "\n\n</details>"
+}
+
+// MarkdownReasoning renders a model's reasoning as a section of its own, open
+// while the model is still thinking and collapsed once the answer follows.
+func MarkdownReasoning(reasoning string, open bool) string {
+	tag := "<details>"
+	if open {
+		tag = "<details open>"
+	}
+	return tag + "\n<summary>Reasoning</summary>\n\n" + strings.TrimSpace(reasoning) + "\n\n</details>"
+}
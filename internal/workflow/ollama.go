@@ -0,0 +1,134 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ollamaProvider speaks Ollama's own chat API, which needs no key.
+type ollamaProvider struct {
+	config ProviderConfig
+	client *http.Client
+}
+
+func (p *ollamaProvider) StreamChat(ctx context.Context, req ChatRequest) (ChatStream, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	type options struct {
+		NumPredict int `json:"num_predict,omitempty"`
+	}
+	body := struct {
+		Model    string    `json:"model"`
+		Messages []message `json:"messages"`
+		Think    bool      `json:"think,omitempty"`
+		Options  options   `json:"options"`
+		Stream   bool      `json:"stream"`
+	}{
+		Model: req.Model,
+		// Models that cannot think reject think, so it is only sent when
+		// reasoning_effort asks for thinking.
+		Think:   req.ReasoningEffort != "" && req.ReasoningEffort != "minimal",
+		Options: options{NumPredict: req.MaxTokens},
+		Stream:  true,
+	}
+	for _, system := range req.System {
+		body.Messages = append(body.Messages, message{Role: "system", Content: system})
+	}
+	for _, m := range req.Messages {
+		body.Messages = append(body.Messages, message{Role: m.Role, Content: m.Content})
+	}
+
+	base := strings.TrimRight(p.config.Endpoint, "/")
+	if base == "" {
+		base = "http://localhost:11434"
+	}
+	for _, suffix := range []string{"/api/chat", "/api", "/v1"} {
+		if strings.HasSuffix(base, suffix) {
+			base = strings.TrimSuffix(base, suffix)
+			break
+		}
+	}
+	resp, err := postStream(ctx, p.client, base+"/api/chat", http.Header{}, body, func(httpErr *HTTPError, data []byte) {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil {
+			describeOllamaError(httpErr, failure.Error)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	return &ollamaStream{body: resp.Body, lines: lines}, nil
+}
+
+func describeOllamaError(httpErr *HTTPError, message string) {
+	httpErr.Message = message
+	if strings.Contains(message, "not found") && strings.Contains(message, "model") {
+		httpErr.Code = "model_not_found"
+	}
+}
+
+type ollamaStream struct {
+	body      io.Closer
+	lines     *bufio.Scanner
+	delta     string
+	reasoning string
+	result    ChatResult
+	err       error
+}
+
+func (s *ollamaStream) Next() bool {
+	for s.lines.Scan() {
+		if len(strings.TrimSpace(s.lines.Text())) == 0 {
+			continue
+		}
+		var chunk struct {
+			Model   string `json:"model"`
+			Message struct {
+				Content  string `json:"content"`
+				Thinking string `json:"thinking"`
+			} `json:"message"`
+			Done            bool   `json:"done"`
+			DoneReason      string `json:"done_reason"`
+			PromptEvalCount int64  `json:"prompt_eval_count"`
+			EvalCount       int64  `json:"eval_count"`
+			Error           string `json:"error"`
+		}
+		if err := json.Unmarshal(s.lines.Bytes(), &chunk); err != nil {
+			s.err = err
+			return false
+		}
+		if chunk.Error != "" {
+			httpErr := &HTTPError{}
+			describeOllamaError(httpErr, chunk.Error)
+			s.err = httpErr
+			return false
+		}
+		s.result.Model = chunk.Model
+		if chunk.Done {
+			s.result.FinishReason = chunk.DoneReason
+			s.result.PromptTokens = chunk.PromptEvalCount
+			s.result.CompletionTokens = chunk.EvalCount
+		}
+		s.delta = chunk.Message.Content
+		s.reasoning = chunk.Message.Thinking
+		return true
+	}
+	s.err = s.lines.Err()
+	return false
+}
+
+func (s *ollamaStream) Delta() string      { return s.delta }
+func (s *ollamaStream) Reasoning() string  { return s.reasoning }
+func (s *ollamaStream) Err() error         { return s.err }
+func (s *ollamaStream) Result() ChatResult { return s.result }
+func (s *ollamaStream) Close() error       { return s.body.Close() }
@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func ndjson(lines ...string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestOllamaStream(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "application/x-ndjson", ndjson(
+		`{"model":"qwen3","message":{"role":"assistant","content":"","thinking":"Hmm. "}}`,
+		`{"model":"qwen3","message":{"role":"assistant","content":"Hel","thinking":"Right."}}`,
+		``,
+		`{"model":"qwen3","message":{"role":"assistant","content":"lo"}}`,
+		`{"model":"qwen3","message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":9,"eval_count":4}`,
+	))
+	// Endpoints copied from OpenAI-compatible settings end in /v1.
+	provider := &ollamaProvider{config: ProviderConfig{Endpoint: api.URL + "/v1"}, client: http.DefaultClient}
+	got, err := readStream(t, provider, ChatRequest{
+		Model:           "qwen3",
+		System:          []string{"Be brief."},
+		Messages:        []Message{{Role: "user", Content: "a"}},
+		MaxTokens:       100,
+		ReasoningEffort: "medium",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if got.answer() != "Hello" {
+		t.Errorf("answer = %q, want Hello", got.answer())
+	}
+	if strings.Join(got.reasoning, "") != "Hmm. Right." {
+		t.Errorf("reasoning = %q, want the thinking of every chunk", got.reasoning)
+	}
+	want := ChatResult{Model: "qwen3", FinishReason: "length", PromptTokens: 9, CompletionTokens: 4}
+	if got.result != want {
+		t.Errorf("result = %+v, want %+v", got.result, want)
+	}
+
+	if api.request.URL.Path != "/api/chat" {
+		t.Errorf("path = %s", api.request.URL.Path)
+	}
+	body := api.requestBody
+	if body["think"] != true || body["stream"] != true {
+		t.Errorf("body = %v", body)
+	}
+	if options, _ := body["options"].(map[string]any); options["num_predict"] != float64(100) {
+		t.Errorf("options = %v", body["options"])
+	}
+	if messages, _ := body["messages"].([]any); len(messages) != 2 || messages[0].(map[string]any)["role"] != "system" {
+		t.Errorf("messages = %v, want the system prompt first", body["messages"])
+	}
+}
+
+func TestOllamaThinkOnlyWhenAsked(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "application/x-ndjson", ndjson(`{"model":"llama3.1","done":true,"done_reason":"stop"}`))
+	provider := &ollamaProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+	for _, effort := range []string{"", "minimal"} {
+		if _, err := readStream(t, provider, ChatRequest{Model: "llama3.1", ReasoningEffort: effort}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := api.requestBody["think"]; ok {
+			t.Errorf("think sent at %q effort", effort)
+		}
+	}
+}
+
+func TestOllamaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   ErrorKind
+	}{
+		{"model not pulled", 404, `{"error":"model \"qwen9\" not found, try pulling it first"}`, ErrorModel},
+		{"bad request", 400, `{"error":"invalid options"}`, ErrorInvalidRequest},
+		{"server error", 500, `{"error":"llama runner process has terminated"}`, ErrorServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, tt.status, "application/json", tt.body)
+			provider := &ollamaProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+			_, err := readStream(t, provider, ChatRequest{Model: "qwen9"})
+			if got := errorKind(err); got != tt.want {
+				t.Errorf("error %v classified as %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaErrorInStream(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK, "application/x-ndjson", ndjson(
+		`{"model":"qwen3","message":{"role":"assistant","content":"Par"}}`,
+		`{"error":"model \"qwen3\" not found"}`,
+	))
+	provider := &ollamaProvider{config: ProviderConfig{Endpoint: api.URL}, client: http.DefaultClient}
+	got, err := readStream(t, provider, ChatRequest{Model: "qwen3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.answer() != "Par" {
+		t.Errorf("answer = %q, want what came before the error", got.answer())
+	}
+	if kind := errorKind(got.err); kind != ErrorModel {
+		t.Errorf("error %v classified as %q, want %q", got.err, kind, ErrorModel)
+	}
+}
@@ -1,30 +1,144 @@
 package workflow
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	openai "github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/ssestream"
+	"github.com/openai/openai-go/shared"
 )
 
 type ClientOptions struct {
 	APIKey  string
 	OrgID   string
 	BaseURL string
+	// ConnectTimeout bounds establishing the connection, TLS included. It
+	// does not limit how long a response may take.
+	ConnectTimeout time.Duration
+	// Azure, when set, replaces APIKey, OrgID and BaseURL.
+	Azure *AzureOptions
 }
 
 func NewClient(opts ClientOptions) (*openai.Client, error) {
-	if opts.APIKey == "" {
-		return nil, fmt.Errorf("openai_api_key not set")
-	}
 	var clientOpts []option.RequestOption
-	clientOpts = append(clientOpts, option.WithAPIKey(opts.APIKey))
-	if opts.OrgID != "" {
-		clientOpts = append(clientOpts, option.WithOrganization(opts.OrgID))
+	if opts.Azure != nil {
+		azureOpts, err := opts.Azure.requestOptions()
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, azureOpts...)
+	} else {
+		if opts.APIKey == "" {
+			return nil, fmt.Errorf("openai_api_key not set")
+		}
+		clientOpts = append(clientOpts, option.WithAPIKey(opts.APIKey))
+		if opts.OrgID != "" {
+			clientOpts = append(clientOpts, option.WithOrganization(opts.OrgID))
+		}
+		if opts.BaseURL != "" {
+			clientOpts = append(clientOpts, option.WithBaseURL(opts.BaseURL))
+		}
 	}
-	if opts.BaseURL != "" {
-		clientOpts = append(clientOpts, option.WithBaseURL(opts.BaseURL))
+	// Requests are retried by Retry, which can report each wait.
+	clientOpts = append(clientOpts, option.WithMaxRetries(0))
+	if opts.ConnectTimeout > 0 {
+		clientOpts = append(clientOpts, option.WithHTTPClient(newHTTPClient(opts.ConnectTimeout)))
 	}
 	client := openai.NewClient(clientOpts...)
 	return &client, nil
 }
+
+type openAIProvider struct {
+	client *openai.Client
+}
+
+func (p *openAIProvider) StreamChat(ctx context.Context, req ChatRequest) (ChatStream, error) {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(req.System)+len(req.Messages))
+	for _, system := range req.System {
+		messages = append(messages, openai.SystemMessage(system))
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "user":
+			messages = append(messages, openai.UserMessage(m.Content))
+		case "assistant":
+			messages = append(messages, openai.AssistantMessage(m.Content))
+		}
+	}
+	params := openai.ChatCompletionNewParams{
+		Model:         req.Model,
+		Messages:      messages,
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)},
+	}
+	// Reasoning models reject max_tokens, while older models and many
+	// compatible servers only know it.
+	reasoning := IsReasoningModel(req.Model)
+	if req.MaxTokens > 0 {
+		if reasoning {
+			params.MaxCompletionTokens = openai.Int(int64(req.MaxTokens))
+		} else {
+			params.MaxTokens = openai.Int(int64(req.MaxTokens))
+		}
+	}
+	if reasoning && req.ReasoningEffort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(req.ReasoningEffort)
+	}
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+	return &openAIStream{stream: stream}, nil
+}
+
+type openAIStream struct {
+	stream    *ssestream.Stream[openai.ChatCompletionChunk]
+	acc       openai.ChatCompletionAccumulator
+	delta     string
+	reasoning string
+}
+
+func (s *openAIStream) Next() bool {
+	if !s.stream.Next() {
+		return false
+	}
+	chunk := s.stream.Current()
+	s.acc.AddChunk(chunk)
+	s.delta, s.reasoning = "", ""
+	if len(chunk.Choices) > 0 {
+		delta := chunk.Choices[0].Delta
+		s.delta = delta.Content
+		s.reasoning = deltaReasoning(delta)
+	}
+	return true
+}
+
+// deltaReasoning returns the reasoning that compatible servers such as
+// DeepSeek, vLLM and OpenRouter stream alongside the answer; OpenAI itself
+// sends none here.
+func deltaReasoning(delta openai.ChatCompletionChunkChoiceDelta) string {
+	for _, field := range []string{"reasoning_content", "reasoning"} {
+		var text string
+		if raw, ok := delta.JSON.ExtraFields[field]; ok && json.Unmarshal([]byte(raw.Raw()), &text) == nil && text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+func (s *openAIStream) Delta() string     { return s.delta }
+func (s *openAIStream) Reasoning() string { return s.reasoning }
+func (s *openAIStream) Err() error        { return s.stream.Err() }
+func (s *openAIStream) Close() error      { return s.stream.Close() }
+
+func (s *openAIStream) Result() ChatResult {
+	result := ChatResult{
+		Model:            s.acc.Model,
+		PromptTokens:     s.acc.Usage.PromptTokens,
+		CompletionTokens: s.acc.Usage.CompletionTokens,
+	}
+	if len(s.acc.Choices) > 0 {
+		result.FinishReason = s.acc.Choices[0].FinishReason
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Price is what a model costs in US dollars. Token prices are per million
+// tokens; image prices are per image and keyed by "quality/size" or "size".
+// A model priced both ways, like gpt-image-1, is charged by the image only
+// for records without token counts, which are estimates made before the
+// request.
+type Price struct {
+	Input  float64            `json:"input,omitempty"`
+	Output float64            `json:"output,omitempty"`
+	Images map[string]float64 `json:"images,omitempty"`
+}
+
+// Prices maps a model name to its price. A model also matches any dated or
+// suffixed version of it, such as gpt-4o-2024-08-06 for gpt-4o, and any
+// Ollama tag of it, such as qwen3:8b for qwen3.
+type Prices map[string]Price
+
+// DefaultPrices are the published list prices at the time of writing. They
+// go stale; usage_prices overrides them per model.
+var DefaultPrices = Prices{
+	"gpt-5.1":                {Input: 1.25, Output: 10},
+	"gpt-5":                  {Input: 1.25, Output: 10},
+	"gpt-5-mini":             {Input: 0.25, Output: 2},
+	"gpt-5-nano":             {Input: 0.05, Output: 0.40},
+	"gpt-4.1":                {Input: 2, Output: 8},
+	"gpt-4.1-mini":           {Input: 0.40, Output: 1.60},
+	"gpt-4.1-nano":           {Input: 0.10, Output: 0.40},
+	"gpt-4o":                 {Input: 2.50, Output: 10},
+	"gpt-4o-mini":            {Input: 0.15, Output: 0.60},
+	"chatgpt-4o-latest":      {Input: 5, Output: 15},
+	"gpt-4-turbo":            {Input: 10, Output: 30},
+	"gpt-4":                  {Input: 30, Output: 60},
+	"gpt-3.5-turbo":          {Input: 0.50, Output: 1.50},
+	"o1":                     {Input: 15, Output: 60},
+	"o1-mini":                {Input: 1.10, Output: 4.40},
+	"o3":                     {Input: 2, Output: 8},
+	"o3-mini":                {Input: 1.10, Output: 4.40},
+	"o4-mini":                {Input: 1.10, Output: 4.40},
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+	"claude-opus-4-5":        {Input: 5, Output: 25},
+	"claude-opus-4":          {Input: 15, Output: 75},
+	"claude-sonnet-4":        {Input: 3, Output: 15},
+	"claude-haiku-4-5":       {Input: 1, Output: 5},
+	"claude-3-7-sonnet":      {Input: 3, Output: 15},
+	"claude-3-5-sonnet":      {Input: 3, Output: 15},
+	"claude-3-5-haiku":       {Input: 0.80, Output: 4},
+	"claude-3-opus":          {Input: 15, Output: 75},
+	"claude-3-haiku":         {Input: 0.25, Output: 1.25},
+	"gemini-2.5-pro":         {Input: 1.25, Output: 10},
+	"gemini-2.5-flash":       {Input: 0.30, Output: 2.50},
+	"gemini-2.5-flash-lite":  {Input: 0.10, Output: 0.40},
+	"gemini-2.0-flash":       {Input: 0.10, Output: 0.40},
+	"gemini-2.0-flash-lite":  {Input: 0.075, Output: 0.30},
+	"gemini-1.5-pro":         {Input: 1.25, Output: 5},
+	"gemini-1.5-flash":       {Input: 0.075, Output: 0.30},
+	"gpt-image-1": {Input: 5, Output: 40, Images: map[string]float64{
+		"low/1024x1024":    0.011,
+		"low/1024x1536":    0.016,
+		"low/1536x1024":    0.016,
+		"medium/1024x1024": 0.042,
+		"medium/1024x1536": 0.063,
+		"medium/1536x1024": 0.063,
+		"high/1024x1024":   0.167,
+		"high/1024x1536":   0.25,
+		"high/1536x1024":   0.25,
+	}},
+	"dall-e-3": {Images: map[string]float64{
+		"standard/1024x1024": 0.04,
+		"standard/1024x1792": 0.08,
+		"standard/1792x1024": 0.08,
+		"hd/1024x1024":       0.08,
+		"hd/1024x1792":       0.12,
+		"hd/1792x1024":       0.12,
+	}},
+	"dall-e-2": {Images: map[string]float64{
+		"256x256":   0.016,
+		"512x512":   0.018,
+		"1024x1024": 0.02,
+	}},
+}
+
+// LoadPrices returns the default prices with the JSON object in usage_prices
+// laid over them, for example {"gpt-4o": {"input": 2.5, "output": 10}}.
+func LoadPrices() (Prices, error) {
+	prices := Prices{}
+	for model, price := range DefaultPrices {
+		prices[model] = price
+	}
+	raw := strings.TrimSpace(os.Getenv("usage_prices"))
+	if raw == "" {
+		return prices, nil
+	}
+	var overrides Prices
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("usage_prices: %w", err)
+	}
+	for model, price := range overrides {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// Lookup finds the price of model, preferring the longest matching name.
+func (p Prices) Lookup(model string) (Price, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+	best := ""
+	for name := range p {
+		if (strings.HasPrefix(model, name+"-") || strings.HasPrefix(model, name+":")) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return p[best], true
+}
+
+// Cost prices a ledger record. It reports false when the model, or the image
+// size it was asked for, has no price.
+func (p Prices) Cost(rec UsageRecord) (float64, bool) {
+	price, ok := p.Lookup(rec.Model)
+	if !ok {
+		return 0, false
+	}
+	cost := (float64(rec.PromptTokens)*price.Input + float64(rec.CompletionTokens)*price.Output) / 1e6
+	if rec.Images > 0 && len(price.Images) > 0 && rec.PromptTokens == 0 && rec.CompletionTokens == 0 {
+		each, ok := price.Images[rec.Quality+"/"+rec.Size]
+		if !ok {
+			each, ok = price.Images[rec.Size]
+		}
+		if !ok {
+			return cost, false
+		}
+		cost += float64(rec.Images) * each
+	}
+	return cost, true
+}
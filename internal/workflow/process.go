@@ -0,0 +1,87 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func ReadPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, errors.New("invalid pid file")
+	}
+	return pid, nil
+}
+
+// StreamProcessRunning reports whether the process recorded in pidFile is
+// still running, without asking ps whether it is still the stream process.
+// It is cheap enough to check on every poll.
+func StreamProcessRunning(pidFile string) bool {
+	pid, err := ReadPID(pidFile)
+	return err == nil && processRunning(pid)
+}
+
+// StreamProcessAlive reports whether the stream process recorded in pidFile
+// is still running. A PID that now belongs to some other program, as happens
+// after a crash and a reboot, counts as gone.
+func StreamProcessAlive(pidFile string) bool {
+	pid, err := ReadPID(pidFile)
+	if err != nil {
+		return false
+	}
+	return processRunning(pid) && isHelperProcess(pid)
+}
+
+// SignalStreamProcess sends sig to the stream process in pidFile if it is
+// still ours to signal.
+func SignalStreamProcess(pidFile string, sig syscall.Signal) error {
+	pid, err := ReadPID(pidFile)
+	if err != nil {
+		return err
+	}
+	if !isHelperProcess(pid) {
+		return errors.New("stream process is not running")
+	}
+	return syscall.Kill(pid, sig)
+}
+
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func isHelperProcess(pid int) bool {
+	out, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "stat=", "-o", "comm=").Output()
+	if err != nil {
+		return false
+	}
+	stat, comm, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	// A zombie has exited and only waits to be reaped.
+	if strings.HasPrefix(stat, "Z") {
+		return false
+	}
+	name := filepath.Base(strings.TrimSpace(comm))
+	if name == "" {
+		return false
+	}
+	names := []string{"chatgpt", helperBinaryName}
+	if exe, err := os.Executable(); err == nil {
+		names = append(names, filepath.Base(exe))
+	}
+	for _, n := range names {
+		// Linux truncates process names to 15 characters.
+		if name == n || (len(name) == 15 && strings.HasPrefix(n, name)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,312 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+	ProviderOllama    = "ollama"
+)
+
+var providerNames = map[string]string{
+	ProviderOpenAI:    "OpenAI",
+	ProviderAnthropic: "Anthropic",
+	ProviderGemini:    "Gemini",
+	ProviderOllama:    "Ollama",
+}
+
+// ProviderConfig is where a provider other than OpenAI is reached.
+type ProviderConfig struct {
+	APIKey   string
+	Endpoint string
+}
+
+// ChatRequest is a chat as every provider takes it: system instructions
+// followed by alternating questions and answers. PreviousResponseID, for
+// providers that keep the conversation, stands for the messages before these.
+//
+// MaxTokens caps the answer and ReasoningEffort sets how hard reasoning
+// models think; zero values leave both to the provider. Reasoning asks for
+// the model's reasoning, or a summary of it, where the provider can send it.
+type ChatRequest struct {
+	Model              string
+	System             []string
+	Messages           []Message
+	PreviousResponseID string
+	MaxTokens          int
+	ReasoningEffort    string
+	Reasoning          bool
+}
+
+// ChatResult describes a streamed answer once it has ended. Fields a
+// provider did not report are left empty; FinishReason uses OpenAI's values.
+type ChatResult struct {
+	Model            string
+	FinishReason     string
+	PromptTokens     int64
+	CompletionTokens int64
+	ResponseID       string
+}
+
+// ChatStream is an answer arriving in pieces. Next advances to the next
+// piece and returns false at the end of the answer or on failure, after
+// which Err tells them apart. A piece is either part of the answer, in Delta,
+// or part of the reasoning before it, in Reasoning.
+type ChatStream interface {
+	Next() bool
+	Delta() string
+	Reasoning() string
+	Err() error
+	Result() ChatResult
+	Close() error
+}
+
+// ChatProvider sends chats to one kind of API.
+type ChatProvider interface {
+	StreamChat(ctx context.Context, req ChatRequest) (ChatStream, error)
+}
+
+// SplitProviderModel works out which provider serves model. A model may name
+// its provider as in "anthropic:claude-sonnet-4-5"; otherwise Claude and
+// Gemini models are recognised by name and anything else goes to fallback.
+// The model is returned without the provider.
+func SplitProviderModel(model, fallback string) (provider, name string) {
+	if prefix, rest, ok := strings.Cut(model, ":"); ok {
+		if _, known := providerNames[strings.ToLower(prefix)]; known {
+			return strings.ToLower(prefix), rest
+		}
+	}
+	switch {
+	case strings.HasPrefix(model, "claude-"):
+		return ProviderAnthropic, model
+	case strings.HasPrefix(model, "gemini-"):
+		return ProviderGemini, model
+	}
+	if fallback == "" {
+		fallback = ProviderOpenAI
+	}
+	return fallback, model
+}
+
+// ChatModel returns the model that answers chats first, routed as Route
+// describes.
+func (e *Env) ChatModel() (routed *Env, provider, name string) {
+	return e.Route(ResolveChatModel(e.GPTModel, e.ChatModelOverride))
+}
+
+// CheckAPIKey fails when provider needs an API key that is not set.
+func (e *Env) CheckAPIKey(provider string) error {
+	key := e.APIKey
+	switch provider {
+	case ProviderOpenAI:
+		if e.Azure != nil {
+			if e.Azure.APIKey == "" && e.Azure.Token == "" {
+				return fmt.Errorf("Azure OpenAI API key missing")
+			}
+			return nil
+		}
+	case ProviderAnthropic:
+		key = e.Anthropic.APIKey
+	case ProviderGemini:
+		key = e.Gemini.APIKey
+	case ProviderOllama:
+		return nil
+	}
+	if key == "" {
+		return fmt.Errorf("%s API key missing", providerNames[provider])
+	}
+	return nil
+}
+
+// NewChatProvider returns the provider serving model, which may be an alias
+// from model_routes, and the model's name there.
+func NewChatProvider(env *Env, model string) (ChatProvider, string, error) {
+	env, provider, name := env.Route(model)
+	if err := env.CheckAPIKey(provider); err != nil {
+		return nil, "", err
+	}
+	httpClient := newHTTPClient(env.ConnectTimeout)
+	switch provider {
+	case ProviderAnthropic:
+		return &anthropicProvider{config: env.Anthropic, client: httpClient}, name, nil
+	case ProviderGemini:
+		return &geminiProvider{config: env.Gemini, client: httpClient}, name, nil
+	case ProviderOllama:
+		return &ollamaProvider{config: env.Ollama, client: httpClient}, name, nil
+	}
+	client, err := NewClient(ClientOptions{
+		APIKey:         env.APIKey,
+		OrgID:          env.OrgID,
+		BaseURL:        NormalizeBaseURL(env.ChatAPIEndpoint, "https://api.openai.com/v1", "/chat/completions", "/responses"),
+		ConnectTimeout: env.ConnectTimeout,
+		Azure:          env.Azure,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if env.ChatAPI == ChatAPIResponses {
+		return &openAIResponsesProvider{client: client, store: env.ServerState}, name, nil
+	}
+	return &openAIProvider{client: client}, name, nil
+}
+
+// CompleteChat reads a whole answer from provider.
+func CompleteChat(ctx context.Context, provider ChatProvider, req ChatRequest) (string, ChatResult, error) {
+	stream, err := provider.StreamChat(ctx, req)
+	if err != nil {
+		return "", ChatResult{}, err
+	}
+	defer stream.Close()
+	var answer strings.Builder
+	for stream.Next() {
+		answer.WriteString(stream.Delta())
+	}
+	return answer.String(), stream.Result(), stream.Err()
+}
+
+// newHTTPClient returns a client whose connection setup, TLS included, is
+// bounded by connectTimeout, or the default client when that is zero.
+func newHTTPClient(connectTimeout time.Duration) *http.Client {
+	if connectTimeout <= 0 {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	return &http.Client{Transport: transport}
+}
+
+// postStream sends body as JSON and returns the response to read the stream
+// from. A failed response is turned into an *HTTPError by parseError, which
+// is given the response body.
+func postStream(ctx context.Context, client *http.Client, url string, header http.Header, body any, parseError func(*HTTPError, []byte)) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	httpErr := &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header}
+	if data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err == nil {
+		parseError(httpErr, data)
+	}
+	if httpErr.Message == "" {
+		// A body that is not the API's own error, as from a proxy, still
+		// gets classified by its status.
+		httpErr.Message = resp.Status
+	}
+	return nil, httpErr
+}
+
+// sseReader splits a server-sent event stream into events.
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	return &sseReader{scanner: scanner}
+}
+
+// next returns the next event with data, false at the end of the stream.
+func (r *sseReader) next() (event string, data []byte, ok bool) {
+	var buf bytes.Buffer
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		switch {
+		case line == "":
+			if buf.Len() > 0 {
+				return event, buf.Bytes(), true
+			}
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if buf.Len() > 0 {
+		return event, buf.Bytes(), true
+	}
+	return "", nil, false
+}
+
+func (r *sseReader) err() error {
+	return r.scanner.Err()
+}
+
+// alternateTurns drops answers before the first question and joins
+// consecutive messages from the same side, as providers that insist on
+// strictly alternating turns require.
+func alternateTurns(msgs []Message) []Message {
+	var turns []Message
+	for _, m := range msgs {
+		if m.Role != "user" && m.Role != "assistant" {
+			continue
+		}
+		if len(turns) == 0 && m.Role != "user" {
+			continue
+		}
+		if n := len(turns); n > 0 && turns[n-1].Role == m.Role {
+			turns[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		turns = append(turns, Message{Role: m.Role, Content: m.Content})
+	}
+	return turns
+}
+
+// summaryModels are the cheap models that summarise the context of chats
+// answered on each provider, unless summary_model names one.
+var summaryModels = map[string]string{
+	ProviderOpenAI:    "gpt-4o-mini",
+	ProviderAnthropic: "claude-3-5-haiku-latest",
+	ProviderGemini:    "gemini-2.5-flash-lite",
+}
+
+// SummaryModelFor returns the model that summarises the context of a chat
+// answered by model: summary_model when set, otherwise a cheap model of the
+// provider serving the chat. Ollama models and models with a route of their
+// own summarise their chats themselves, since nothing else is known to be
+// served there.
+func (e *Env) SummaryModelFor(model string) string {
+	if e.SummaryModel != "" {
+		return e.SummaryModel
+	}
+	if _, routed := e.Routes[model]; routed {
+		return model
+	}
+	_, provider, _ := e.Route(model)
+	if cheap, ok := summaryModels[provider]; ok {
+		return provider + ":" + cheap
+	}
+	return model
+}
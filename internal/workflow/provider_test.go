@@ -0,0 +1,170 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeAPI stands in for a provider's API: it answers every request with
+// status and body, and keeps the last request for the test to inspect.
+type fakeAPI struct {
+	*httptest.Server
+	status      int
+	contentType string
+	body        string
+
+	request     *http.Request
+	requestBody map[string]any
+}
+
+func newFakeAPI(t *testing.T, status int, contentType, body string) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{status: status, contentType: contentType, body: body}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		api.request = r
+		api.requestBody = nil
+		json.Unmarshal(data, &api.requestBody)
+		w.Header().Set("Content-Type", api.contentType)
+		w.WriteHeader(api.status)
+		io.WriteString(w, api.body)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// sse joins events into a server-sent event stream.
+func sse(events ...string) string {
+	var b strings.Builder
+	for _, event := range events {
+		b.WriteString("data: " + event + "\n\n")
+	}
+	return b.String()
+}
+
+// streamed is everything a ChatStream produced.
+type streamed struct {
+	deltas    []string
+	reasoning []string
+	result    ChatResult
+	err       error
+}
+
+func (s streamed) answer() string { return strings.Join(s.deltas, "") }
+
+func readStream(t *testing.T, provider ChatProvider, req ChatRequest) (streamed, error) {
+	t.Helper()
+	stream, err := provider.StreamChat(context.Background(), req)
+	if err != nil {
+		return streamed{}, err
+	}
+	defer stream.Close()
+	var got streamed
+	for stream.Next() {
+		if delta := stream.Delta(); delta != "" {
+			got.deltas = append(got.deltas, delta)
+		}
+		if thought := stream.Reasoning(); thought != "" {
+			got.reasoning = append(got.reasoning, thought)
+		}
+	}
+	got.result = stream.Result()
+	got.err = stream.Err()
+	return got, nil
+}
+
+// errorKind is the kind ClassifyError gives err, or "none" for no error.
+func errorKind(err error) ErrorKind {
+	if err == nil {
+		return "none"
+	}
+	return ClassifyError(err).Kind
+}
+
+func TestAlternateTurns(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []Message
+		want []Message
+	}{
+		{"empty", nil, nil},
+		{
+			"already alternating",
+			[]Message{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}},
+			[]Message{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}},
+		},
+		{
+			"leading answer dropped",
+			[]Message{{Role: "assistant", Content: "hi"}, {Role: "user", Content: "a"}},
+			[]Message{{Role: "user", Content: "a"}},
+		},
+		{
+			"consecutive turns joined",
+			[]Message{{Role: "user", Content: "a"}, {Role: "user", Content: "b"}, {Role: "assistant", Content: "c"}, {Role: "assistant", Content: "d"}},
+			[]Message{{Role: "user", Content: "a\n\nb"}, {Role: "assistant", Content: "c\n\nd"}},
+		},
+		{
+			"other roles skipped",
+			[]Message{{Role: "system", Content: "s"}, {Role: "user", Content: "a"}, {Role: "tool", Content: "t"}, {Role: "user", Content: "b"}},
+			[]Message{{Role: "user", Content: "a\n\nb"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := alternateTurns(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("alternateTurns = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	stream := "event: ping\ndata: {}\n\n: comment\ndata: line one\ndata: line two\n\ndata: last"
+	r := newSSEReader(strings.NewReader(stream))
+	want := []struct{ event, data string }{{"ping", "{}"}, {"", "line one\nline two"}, {"", "last"}}
+	for _, w := range want {
+		event, data, ok := r.next()
+		if !ok || event != w.event || string(data) != w.data {
+			t.Fatalf("next() = %q, %q, %v; want %q, %q", event, data, ok, w.event, w.data)
+		}
+	}
+	if _, _, ok := r.next(); ok {
+		t.Error("next() found an event past the end")
+	}
+}
+
+func TestSummaryModelFor(t *testing.T) {
+	env := &Env{
+		ChatProvider: ProviderOpenAI,
+		Routes:       map[string]ModelRoute{"work": {Model: "gpt-4o", Endpoint: "https://llm.example.com/v1"}},
+	}
+	tests := map[string]string{
+		"gpt-4o":                    "openai:gpt-4o-mini",
+		"claude-sonnet-4-5":         "anthropic:claude-3-5-haiku-latest",
+		"gemini:gemini-2.5-pro":     "gemini:gemini-2.5-flash-lite",
+		"ollama:llama3.1":           "ollama:llama3.1",
+		"work":                      "work",
+		"anthropic:claude-opus-4-1": "anthropic:claude-3-5-haiku-latest",
+		"gemini-2.0-flash":          "gemini:gemini-2.5-flash-lite",
+	}
+	for model, want := range tests {
+		if got := env.SummaryModelFor(model); got != want {
+			t.Errorf("SummaryModelFor(%q) = %q, want %q", model, got, want)
+		}
+	}
+
+	env.ChatProvider = ProviderAnthropic
+	if got := env.SummaryModelFor("claude-sonnet-4-5"); got != "anthropic:claude-3-5-haiku-latest" {
+		t.Errorf("with chat_provider anthropic: %q", got)
+	}
+	env.SummaryModel = "gpt-4.1-nano"
+	if got := env.SummaryModelFor("claude-sonnet-4-5"); got != "gpt-4.1-nano" {
+		t.Errorf("summary_model ignored: %q", got)
+	}
+}
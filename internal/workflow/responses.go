@@ -0,0 +1,165 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	openai "github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/packages/ssestream"
+	"github.com/openai/openai-go/responses"
+	"github.com/openai/openai-go/shared"
+)
+
+// ChatAPIResponses selects OpenAI's Responses API for chats in chat_api.
+const ChatAPIResponses = "responses"
+
+// openAIResponsesProvider sends chats to OpenAI's Responses API. With store
+// set, answers are kept on the server and a chat can be continued from the ID
+// of its last answer instead of being sent again.
+type openAIResponsesProvider struct {
+	client *openai.Client
+	store  bool
+}
+
+func (p *openAIResponsesProvider) StreamChat(ctx context.Context, req ChatRequest) (ChatStream, error) {
+	input := make(responses.ResponseInputParam, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "user":
+			input = append(input, responses.ResponseInputItemParamOfMessage(m.Content, responses.EasyInputMessageRoleUser))
+		case "assistant":
+			input = append(input, responses.ResponseInputItemParamOfMessage(m.Content, responses.EasyInputMessageRoleAssistant))
+		}
+	}
+	params := responses.ResponseNewParams{
+		Model: req.Model,
+		Input: responses.ResponseNewParamsInputUnion{OfInputItemList: input},
+		Store: param.NewOpt(p.store),
+	}
+	if len(req.System) > 0 {
+		params.Instructions = param.NewOpt(strings.Join(req.System, "\n\n"))
+	}
+	if req.MaxTokens > 0 {
+		params.MaxOutputTokens = param.NewOpt(int64(req.MaxTokens))
+	}
+	if IsReasoningModel(req.Model) {
+		params.Reasoning.Effort = shared.ReasoningEffort(req.ReasoningEffort)
+		if req.Reasoning {
+			params.Reasoning.Summary = shared.ReasoningSummaryAuto
+		}
+	}
+	if req.PreviousResponseID != "" {
+		params.PreviousResponseID = param.NewOpt(req.PreviousResponseID)
+		// The stored conversation is not trimmed locally, so the server
+		// drops its oldest turns instead once it outgrows the context.
+		params.Truncation = responses.ResponseNewParamsTruncationAuto
+	}
+	stream := p.client.Responses.NewStreaming(ctx, params)
+	return &openAIResponsesStream{stream: stream, stored: p.store}, nil
+}
+
+// KeepsConversation reports whether provider stores answers on the server, so
+// that a chat can be continued from the ID of its last answer alone.
+func KeepsConversation(provider ChatProvider) bool {
+	p, ok := provider.(*openAIResponsesProvider)
+	return ok && p.store
+}
+
+// ResumePoint returns the server-side ID of the last answer in chat and the
+// messages after it, or an empty ID when that answer has none.
+func ResumePoint(chat []Message) (string, []Message) {
+	i := LastAssistant(chat)
+	if i < 0 || chat[i].ResponseID == "" {
+		return "", nil
+	}
+	return chat[i].ResponseID, chat[i+1:]
+}
+
+// IsMissingPreviousResponse reports whether err says that the answer a
+// request continued from is no longer stored, as after the retention period.
+func IsMissingPreviousResponse(err error) bool {
+	classified := ClassifyError(err)
+	return classified.Kind == ErrorInvalidRequest && strings.Contains(strings.ToLower(classified.Detail), "previous response")
+}
+
+// Statuses for failures reported inside the stream, which arrive without one.
+var responsesErrorStatus = map[string]int{
+	"server_error":        http.StatusInternalServerError,
+	"rate_limit_exceeded": http.StatusTooManyRequests,
+}
+
+type openAIResponsesStream struct {
+	stream    *ssestream.Stream[responses.ResponseStreamEventUnion]
+	stored    bool
+	delta     string
+	reasoning string
+	result    ChatResult
+	err       error
+}
+
+func (s *openAIResponsesStream) Next() bool {
+	s.delta, s.reasoning = "", ""
+	for s.stream.Next() {
+		event := s.stream.Current()
+		switch event.Type {
+		case "response.created", "response.in_progress":
+			s.result.Model = event.Response.Model
+			s.result.ResponseID = event.Response.ID
+		case "response.output_text.delta":
+			s.delta = event.Delta.OfString
+			return true
+		case "response.reasoning_summary_part.added":
+			if event.SummaryIndex > 0 {
+				s.reasoning = "\n\n"
+				return true
+			}
+		case "response.reasoning_summary_text.delta":
+			s.reasoning = event.Delta.OfString
+			return true
+		case "response.completed", "response.incomplete":
+			response := event.Response
+			s.result.Model = response.Model
+			s.result.ResponseID = response.ID
+			s.result.PromptTokens = response.Usage.InputTokens
+			s.result.CompletionTokens = response.Usage.OutputTokens
+			s.result.FinishReason = "stop"
+			switch response.IncompleteDetails.Reason {
+			case "max_output_tokens":
+				s.result.FinishReason = "length"
+			case "content_filter":
+				s.result.FinishReason = "content_filter"
+			}
+		case "response.failed":
+			failure := event.Response.Error
+			s.err = &HTTPError{StatusCode: responsesErrorStatus[string(failure.Code)], Code: string(failure.Code), Message: failure.Message}
+			return false
+		case "error":
+			s.err = &HTTPError{StatusCode: responsesErrorStatus[event.Code], Code: event.Code, Message: event.Message}
+			return false
+		}
+	}
+	return false
+}
+
+func (s *openAIResponsesStream) Delta() string     { return s.delta }
+func (s *openAIResponsesStream) Reasoning() string { return s.reasoning }
+
+// Result reports the answer's ID only when the answer is stored and can be
+// continued from.
+func (s *openAIResponsesStream) Result() ChatResult {
+	result := s.result
+	if !s.stored {
+		result.ResponseID = ""
+	}
+	return result
+}
+func (s *openAIResponsesStream) Close() error { return s.stream.Close() }
+
+func (s *openAIResponsesStream) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.stream.Err()
+}
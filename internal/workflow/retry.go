@@ -0,0 +1,179 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	openai "github.com/openai/openai-go"
+)
+
+// RetryPolicy decides how often and how long to wait before repeating a
+// request that failed for a transient reason. The client library's own
+// retries are turned off so that every wait goes through here and can be
+// shown to the user.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// HTTPError is an unsuccessful response to a plain HTTP request, such as an
+// image download or a call to a provider other than OpenAI. Code and Message
+// carry the error the server described, with Code in OpenAI's terms where
+// there is one.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Code       string
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Status
+}
+
+// Retry calls fn until it succeeds, fails for a reason retrying cannot fix or
+// runs out of attempts, and returns the last error. While waiting, status is
+// called once a second with a description such as "Rate limited, retrying in
+// 4s…"; it may be nil.
+func Retry(ctx context.Context, policy RetryPolicy, status func(string), fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		delay, ok := policy.delay(err, attempt)
+		if !ok {
+			return err
+		}
+		reason := retryReason(err)
+		for remaining := delay; remaining > 0; remaining -= time.Second {
+			if status != nil {
+				status(fmt.Sprintf("%s, retrying in %ds (attempt %d of %d)…", reason, int((remaining+time.Second-1)/time.Second), attempt+1, policy.MaxAttempts))
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(min(remaining, time.Second)):
+			}
+		}
+	}
+}
+
+// delay returns how long to wait before attempt+1, preferring what the server
+// asked for. A server asking for longer than MaxDelay is not retried.
+func (p RetryPolicy) delay(err error, attempt int) (time.Duration, bool) {
+	status, header, ok := retryableResponse(err)
+	if !ok {
+		return 0, false
+	}
+	if status != 0 {
+		if hint, ok := serverDelay(header); ok {
+			return hint, hint <= p.MaxDelay
+		}
+	}
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	// Jitter over the upper half of the backoff keeps clients sharing a key from
+	// retrying in lockstep.
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)), true
+}
+
+// retryableResponse reports whether err is transient, together with the HTTP
+// status and headers when it came from a response. Network failures are
+// retryable and have no status.
+func retryableResponse(err error) (int, http.Header, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, nil, false
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		// Running out of quota looks like rate limiting but only billing fixes it.
+		if apiErr.Code == "insufficient_quota" {
+			return 0, nil, false
+		}
+		var header http.Header
+		if apiErr.Response != nil {
+			header = apiErr.Response.Header
+		}
+		return apiErr.StatusCode, header, retryableStatus(apiErr.StatusCode)
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.Code == "insufficient_quota" {
+			return 0, nil, false
+		}
+		return httpErr.StatusCode, httpErr.Header, retryableStatus(httpErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return 0, nil, true
+	}
+	return 0, nil, false
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusConflict || status == http.StatusTooManyRequests || status >= 500
+}
+
+// serverDelay reads the wait a response asked for from Retry-After, its
+// millisecond variant, or OpenAI's rate limit reset headers when a limit is
+// used up.
+func serverDelay(header http.Header) (time.Duration, bool) {
+	if header == nil {
+		return 0, false
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return max(time.Until(at), 0), true
+		}
+	}
+	for _, limit := range []string{"requests", "tokens"} {
+		if header.Get("X-Ratelimit-Remaining-"+limit) != "0" {
+			continue
+		}
+		if reset, err := time.ParseDuration(header.Get("X-Ratelimit-Reset-" + limit)); err == nil {
+			return reset, true
+		}
+	}
+	return 0, false
+}
+
+func retryReason(err error) string {
+	status, _, _ := retryableResponse(err)
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "Rate limited"
+	case status >= 500:
+		return fmt.Sprintf("Server error (%d)", status)
+	case status != 0:
+		return fmt.Sprintf("Request failed (%d)", status)
+	}
+	return "Connection failed"
+}
@@ -0,0 +1,115 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ModelRoute is what a model alias in model_routes stands for: a model,
+// optionally at its own provider, endpoint and key. Empty fields fall back to
+// the workflow's settings for the provider.
+type ModelRoute struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Endpoint string `json:"endpoint"`
+	APIKey   string `json:"api_key"`
+}
+
+// LoadModelRoutes reads model_routes, a JSON object from alias to route such
+// as {"fast": {"provider": "ollama", "model": "llama3.1"}}.
+func LoadModelRoutes() (map[string]ModelRoute, error) {
+	raw := strings.TrimSpace(os.Getenv("model_routes"))
+	if raw == "" {
+		return nil, nil
+	}
+	var routes map[string]ModelRoute
+	if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+		return nil, fmt.Errorf("model_routes: %w", err)
+	}
+	for alias, route := range routes {
+		if route.Model == "" {
+			return nil, fmt.Errorf("model_routes: %q has no model", alias)
+		}
+	}
+	return routes, nil
+}
+
+// splitList splits a comma or newline separated setting, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Route returns the settings to send model with, the provider serving it and
+// its name there. For an alias in model_routes these are the route's, with the
+// route's endpoint and key in place of the provider's; any other model is sent
+// as SplitProviderModel says with env unchanged.
+func (e *Env) Route(model string) (routed *Env, provider, name string) {
+	route, ok := e.Routes[model]
+	if !ok {
+		provider, name = SplitProviderModel(model, e.ChatProvider)
+		return e, provider, name
+	}
+	if route.Provider != "" {
+		provider, name = strings.ToLower(route.Provider), route.Model
+	} else {
+		provider, name = SplitProviderModel(route.Model, e.ChatProvider)
+	}
+
+	copied := *e
+	routed = &copied
+	var config *ProviderConfig
+	switch provider {
+	case ProviderAnthropic:
+		config = &routed.Anthropic
+	case ProviderGemini:
+		config = &routed.Gemini
+	case ProviderOllama:
+		config = &routed.Ollama
+	default:
+		if route.Endpoint != "" {
+			// An OpenAI-compatible endpoint of its own replaces Azure.
+			routed.ChatAPIEndpoint = route.Endpoint
+			routed.Azure = nil
+		}
+		if route.APIKey != "" {
+			routed.APIKey = route.APIKey
+			if routed.Azure != nil {
+				azure := *routed.Azure
+				azure.APIKey, azure.Token = route.APIKey, ""
+				routed.Azure = &azure
+			}
+		}
+		return routed, provider, name
+	}
+	if route.Endpoint != "" {
+		config.Endpoint = route.Endpoint
+	}
+	if route.APIKey != "" {
+		config.APIKey = route.APIKey
+	}
+	return routed, provider, name
+}
+
+// ShouldFallBack reports whether a model that failed with err should give way
+// to the next one in model_fallbacks: the service is failing or overloaded, or
+// nothing arrived in time.
+func ShouldFallBack(err error) bool {
+	if errors.Is(err, ErrFirstTokenTimeout) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return ClassifyError(err).Kind == ErrorServer
+}
@@ -0,0 +1,375 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 tuning used to rank messages.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+const snippetRadius = 60
+
+// SearchIndex is an inverted index over every message of the archived chats.
+// Docs are keyed by archive ID and remember the file state they were indexed
+// from, so refreshing only reads archives that changed.
+type SearchIndex struct {
+	Docs     map[string]IndexedDoc `json:"docs"`
+	Postings map[string][]Posting  `json:"postings"`
+}
+
+type IndexedDoc struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	Title   string    `json:"title"`
+	Lengths []int     `json:"lengths"`
+}
+
+type Posting struct {
+	Doc  string `json:"d"`
+	Msg  int    `json:"m"`
+	Freq int    `json:"f"`
+}
+
+type SearchHit struct {
+	ID      string
+	Title   string
+	Msg     int
+	Score   float64
+	Snippet string
+}
+
+func IndexDir(dataDir string) string {
+	return filepath.Join(dataDir, "index")
+}
+
+func SearchIndexPath(dataDir string) string {
+	return filepath.Join(IndexDir(dataDir), "fulltext.json")
+}
+
+func ReadSearchIndex(path string) (*SearchIndex, error) {
+	idx := &SearchIndex{Docs: map[string]IndexedDoc{}, Postings: map[string][]Posting{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	decoded, err := maybeDecrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return idx, nil
+	}
+	if err := json.Unmarshal(decoded, idx); err != nil {
+		return nil, err
+	}
+	if idx.Docs == nil {
+		idx.Docs = map[string]IndexedDoc{}
+	}
+	if idx.Postings == nil {
+		idx.Postings = map[string][]Posting{}
+	}
+	return idx, nil
+}
+
+func WriteSearchIndex(path string, idx *SearchIndex) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	payload, err := maybeEncrypt(data)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(path, payload)
+}
+
+// RefreshSearchIndex brings the index at indexPath in line with the archives
+// in archiveDir, reading only archives added or changed since the last run.
+func RefreshSearchIndex(indexPath, archiveDir string) (*SearchIndex, error) {
+	idx, err := ReadSearchIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	changed := false
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		seen[id] = true
+		if doc, ok := idx.Docs[id]; ok && doc.Size == info.Size() && doc.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+		msgs, err := ReadChat(filepath.Join(archiveDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		idx.remove(id)
+		idx.add(id, msgs, info)
+		changed = true
+	}
+	for id := range idx.Docs {
+		if !seen[id] {
+			idx.remove(id)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := WriteSearchIndex(indexPath, idx); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+func (idx *SearchIndex) add(id string, msgs []Message, info fs.FileInfo) {
+	doc := IndexedDoc{ModTime: info.ModTime(), Size: info.Size(), Lengths: make([]int, len(msgs))}
+	for i, m := range msgs {
+		if doc.Title == "" && m.Role == "user" {
+			doc.Title = m.Content
+		}
+		freqs := map[string]int{}
+		terms := SearchTerms(m.Content)
+		for _, term := range terms {
+			freqs[term]++
+		}
+		doc.Lengths[i] = len(terms)
+		for term, freq := range freqs {
+			idx.Postings[term] = append(idx.Postings[term], Posting{Doc: id, Msg: i, Freq: freq})
+		}
+	}
+	idx.Docs[id] = doc
+}
+
+func (idx *SearchIndex) remove(id string) {
+	if _, ok := idx.Docs[id]; !ok {
+		return
+	}
+	delete(idx.Docs, id)
+	for term, postings := range idx.Postings {
+		kept := postings[:0]
+		for _, p := range postings {
+			if p.Doc != id {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(idx.Postings, term)
+		} else {
+			idx.Postings[term] = kept
+		}
+	}
+}
+
+// Search ranks archived chats by the BM25 score of their best matching
+// message. The last query word also matches as a prefix so results update
+// while typing.
+func (idx *SearchIndex) Search(query string, limit int) []SearchHit {
+	terms := SearchTerms(query)
+	if len(terms) == 0 || len(idx.Docs) == 0 {
+		return nil
+	}
+
+	totalMsgs, totalLen := 0, 0
+	for _, doc := range idx.Docs {
+		totalMsgs += len(doc.Lengths)
+		for _, l := range doc.Lengths {
+			totalLen += l
+		}
+	}
+	if totalMsgs == 0 {
+		return nil
+	}
+	avgLen := float64(totalLen) / float64(totalMsgs)
+
+	type msgKey struct {
+		doc string
+		msg int
+	}
+	scores := map[msgKey]float64{}
+	for i, term := range terms {
+		matched := []string{term}
+		if i == len(terms)-1 {
+			matched = idx.prefixTerms(term)
+		}
+		for _, t := range matched {
+			postings := idx.Postings[t]
+			idf := math.Log(1 + (float64(totalMsgs)-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for _, p := range postings {
+				length := float64(idx.Docs[p.Doc].Lengths[p.Msg])
+				tf := float64(p.Freq)
+				scores[msgKey{p.Doc, p.Msg}] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLen))
+			}
+		}
+	}
+
+	best := map[string]SearchHit{}
+	for key, score := range scores {
+		if hit, ok := best[key.doc]; !ok || score > hit.Score {
+			best[key.doc] = SearchHit{ID: key.doc, Title: idx.Docs[key.doc].Title, Msg: key.msg, Score: score}
+		}
+	}
+	hits := make([]SearchHit, 0, len(best))
+	for _, hit := range best {
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func (idx *SearchIndex) prefixTerms(prefix string) []string {
+	var terms []string
+	for term := range idx.Postings {
+		if strings.HasPrefix(term, prefix) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// SearchTerms lowercases text and splits it into indexable words. Scripts
+// written without spaces, such as Chinese or Japanese, index every character.
+func SearchTerms(text string) []string {
+	var terms []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			terms = append(terms, string(r))
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_':
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+// Snippet cuts the part of text around the first query match and marks every
+// matched word with «».
+func Snippet(text, query string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	terms := SearchTerms(query)
+	first := -1
+	for i := 0; i < len(text) && first < 0; {
+		for _, term := range terms {
+			if foldPrefix(text[i:], term) > 0 {
+				first = i
+				break
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start := max(0, first-snippetRadius)
+	end := min(len(text), first+snippetRadius)
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+	window := text[start:end]
+	highlighted := highlightTerms(window, terms)
+	if start > 0 {
+		highlighted = "…" + highlighted
+	}
+	if end < len(text) {
+		highlighted += "…"
+	}
+	return highlighted
+}
+
+func highlightTerms(text string, terms []string) string {
+	if len(terms) == 0 {
+		return text
+	}
+	var builder strings.Builder
+	for i := 0; i < len(text); {
+		matched := 0
+		for _, term := range terms {
+			matched = max(matched, foldPrefix(text[i:], term))
+		}
+		if matched == 0 {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			builder.WriteString(text[i : i+size])
+			i += size
+			continue
+		}
+		builder.WriteString("«")
+		builder.WriteString(text[i : i+matched])
+		builder.WriteString("»")
+		i += matched
+	}
+	return builder.String()
+}
+
+// foldPrefix returns the length in bytes of the start of text that matches the
+// lowercased term, or 0 when text does not start with it. Text is compared rune
+// by rune as SearchTerms lowercases it, so the length is one of text's own
+// even where lowercasing changes a rune's size.
+func foldPrefix(text, term string) int {
+	n := 0
+	for _, want := range term {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if size == 0 || unicode.ToLower(r) != want {
+			return 0
+		}
+		n += size
+	}
+	return n
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
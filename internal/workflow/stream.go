@@ -7,10 +7,27 @@ import (
 	"time"
 )
 
+// Finish reasons set by the workflow rather than the API: the user stopped
+// the answer, or it stopped arriving within the configured timeouts.
+const (
+	FinishCancelled = "cancelled"
+	FinishStalled   = "stalled"
+)
+
 type StreamState struct {
-	Content      string `json:"content"`
-	FinishReason string `json:"finish_reason,omitempty"`
-	Error        string `json:"error,omitempty"`
+	Content          string    `json:"content"`
+	FinishReason     string    `json:"finish_reason,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	ErrorKind        ErrorKind `json:"error_kind,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int64     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int64     `json:"completion_tokens,omitempty"`
+	Status           string    `json:"status,omitempty"`
+	Dropped          int       `json:"dropped,omitempty"`
+	FallbackFor      string    `json:"fallback_for,omitempty"`
+	SummaryError     string    `json:"summary_error,omitempty"`
+	ResponseID       string    `json:"response_id,omitempty"`
+	Reasoning        string    `json:"reasoning,omitempty"`
 }
 
 func WriteStreamState(path string, state StreamState) error {
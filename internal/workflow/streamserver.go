@@ -0,0 +1,185 @@
+package workflow
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The stream file is rewritten at most this often while a socket serves
+	// the answer; it only has to be recent enough to recover from a crash.
+	streamFlushInterval = time.Second
+	streamSocketTimeout = 500 * time.Millisecond
+	// macOS limits socket paths to 104 bytes.
+	maxSocketPath = 100
+)
+
+// StreamUpdate is the reply to a poller: the answer from Offset on in
+// Content, and the rest of the state as it stands.
+type StreamUpdate struct {
+	StreamState
+	Offset int `json:"offset"`
+}
+
+// socketPath keeps the socket next to the stream file when the path fits,
+// and otherwise uses a name derived from it in the temporary directory.
+func socketPath(path string) string {
+	if len(path) <= maxSocketPath {
+		return path
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(os.TempDir(), "chatgpt-"+hex.EncodeToString(sum[:8])+".sock")
+}
+
+// StreamServer holds a stream's progress in memory and serves it to pollers
+// over a Unix socket, so that a poll reads only what is new instead of the
+// whole answer from disk.
+type StreamServer struct {
+	file     string
+	socket   string
+	listener net.Listener
+
+	mu      sync.Mutex
+	state   StreamState
+	written time.Time
+	flush   *time.Timer
+	done    bool
+}
+
+// ServeStream starts serving the stream recorded in file. When the socket
+// cannot be opened every update is written to file instead.
+func ServeStream(file, socket string) *StreamServer {
+	s := &StreamServer{file: file, socket: socket}
+	// A socket left by a crashed stream would make Listen fail.
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "stream socket:", err)
+		return s
+	}
+	os.Chmod(socket, 0o600)
+	s.listener = listener
+	go s.serve(listener)
+	return s
+}
+
+func (s *StreamServer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.reply(conn)
+	}
+}
+
+func (s *StreamServer) reply(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(streamSocketTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	offset, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || offset < 0 {
+		return
+	}
+	s.mu.Lock()
+	update := StreamUpdate{StreamState: s.state, Offset: offset}
+	s.mu.Unlock()
+	if offset < len(update.Content) {
+		update.Content = update.Content[offset:]
+	} else {
+		update.Content = ""
+	}
+	json.NewEncoder(conn).Encode(update)
+}
+
+// Publish makes state the stream's current progress.
+func (s *StreamServer) Publish(state StreamState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	if s.listener == nil {
+		s.write()
+		return
+	}
+	if wait := streamFlushInterval - time.Since(s.written); wait > 0 {
+		if s.flush == nil {
+			s.flush = time.AfterFunc(wait, s.flushState)
+		}
+		return
+	}
+	s.write()
+}
+
+func (s *StreamServer) flushState() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush = nil
+	if !s.done {
+		s.write()
+	}
+}
+
+func (s *StreamServer) write() error {
+	s.written = time.Now()
+	return WriteStreamState(s.file, s.state)
+}
+
+// Finish stops serving and writes the final state to the stream file, where
+// the poller reads it once the socket is gone.
+func (s *StreamServer) Finish(state StreamState) error {
+	s.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return s.write()
+}
+
+// Close stops serving the socket and removes it. Later updates go straight
+// to the stream file.
+func (s *StreamServer) Close() {
+	s.mu.Lock()
+	s.done = true
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+	listener := s.listener
+	s.listener = nil
+	s.mu.Unlock()
+	if listener != nil {
+		listener.Close()
+		os.Remove(s.socket)
+	}
+}
+
+// RequestStreamUpdate asks the stream process for its progress, with the
+// answer from offset on.
+func RequestStreamUpdate(socket string, offset int) (StreamUpdate, error) {
+	conn, err := net.DialTimeout("unix", socket, streamSocketTimeout)
+	if err != nil {
+		return StreamUpdate{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(streamSocketTimeout))
+	if _, err := fmt.Fprintf(conn, "%d\n", offset); err != nil {
+		return StreamUpdate{}, err
+	}
+	var update StreamUpdate
+	if err := json.NewDecoder(conn).Decode(&update); err != nil {
+		return StreamUpdate{}, err
+	}
+	return update, nil
+}
@@ -0,0 +1,101 @@
+package workflow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const SummaryInstructions = "You maintain a running summary of a conversation between a user and an assistant. " +
+	"Merge the new turns into the existing summary. Keep facts, decisions, names, code identifiers and open questions; " +
+	"drop pleasantries. Reply with the updated summary only, in the conversation's language, in at most 250 words."
+
+// ChatSummary condenses the oldest messages of a chat that no longer fit in
+// the context window. Covered counts the leading messages folded into Text and
+// Digest fingerprints them, so a summary never leaks into a different chat.
+type ChatSummary struct {
+	Text    string `json:"text"`
+	Covered int    `json:"covered"`
+	Digest  string `json:"digest"`
+}
+
+func SummaryPath(chatPath string) string {
+	return strings.TrimSuffix(chatPath, ".json") + ".summary"
+}
+
+func ReadSummary(path string) (ChatSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ChatSummary{}, nil
+		}
+		return ChatSummary{}, err
+	}
+	decoded, err := maybeDecrypt(data)
+	if err != nil {
+		return ChatSummary{}, err
+	}
+	if len(decoded) == 0 {
+		return ChatSummary{}, nil
+	}
+	var summary ChatSummary
+	if err := json.Unmarshal(decoded, &summary); err != nil {
+		return ChatSummary{}, err
+	}
+	return summary, nil
+}
+
+func WriteSummary(path string, summary ChatSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	payload, err := maybeEncrypt(data)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(path, payload)
+}
+
+// Matches reports whether the summary was built from the start of messages.
+func (s ChatSummary) Matches(messages []Message) bool {
+	if s.Covered <= 0 || s.Covered > len(messages) {
+		return false
+	}
+	return s.Digest == SummaryDigest(messages[:s.Covered])
+}
+
+func SummaryDigest(messages []Message) string {
+	hash := sha256.New()
+	for _, m := range messages {
+		hash.Write([]byte(m.Role))
+		hash.Write([]byte{0})
+		hash.Write([]byte(m.Content))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// SummaryPrompt asks for previous to be extended with the given turns.
+func SummaryPrompt(previous string, messages []Message) string {
+	var builder strings.Builder
+	builder.WriteString("Existing summary:\n")
+	if previous == "" {
+		builder.WriteString("(none)")
+	} else {
+		builder.WriteString(previous)
+	}
+	builder.WriteString("\n\nNew turns:\n")
+	for _, m := range messages {
+		builder.WriteString("\n")
+		builder.WriteString(m.Role)
+		builder.WriteString(": ")
+		builder.WriteString(m.Content)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,112 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Encoding names the BPE vocabulary a model family tokenizes with.
+type Encoding string
+
+const (
+	EncodingCL100K Encoding = "cl100k_base"
+	EncodingO200K  Encoding = "o200k_base"
+)
+
+// Per-message framing the chat format adds around every message, plus the
+// tokens that prime the assistant reply.
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+var o200kPrefixes = []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "chatgpt-4o", "o1", "o3", "o4"}
+
+func EncodingForModel(model string) Encoding {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	for _, prefix := range o200kPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return EncodingO200K
+		}
+	}
+	return EncodingCL100K
+}
+
+// CountTokens returns how many tokens text encodes to. Special tokens such as
+// <|endoftext|> are counted as the plain text they are sent as.
+func CountTokens(enc Encoding, text string) int {
+	if text == "" {
+		return 0
+	}
+	return len(encoder(enc).EncodeOrdinary(text))
+}
+
+func MessageTokens(enc Encoding, msg Message) int {
+	return tokensPerMessage + CountTokens(enc, msg.Role) + CountTokens(enc, msg.Content)
+}
+
+func ContextTokens(enc Encoding, systemPrompt string, messages []Message) int {
+	total := tokensPerReply
+	if systemPrompt != "" {
+		total += MessageTokens(enc, Message{Role: "system", Content: systemPrompt})
+	}
+	for _, m := range messages {
+		total += MessageTokens(enc, m)
+	}
+	return total
+}
+
+// TruncateTokens keeps the tail of text that fits in max tokens.
+func TruncateTokens(enc Encoding, text string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if CountTokens(enc, text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if CountTokens(enc, string(runes[mid:])) <= max {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return string(runes[lo:])
+}
+
+var (
+	encodersMu sync.Mutex
+	encoders   = map[Encoding]*tiktoken.Tiktoken{}
+)
+
+// encoder returns the tokenizer for enc, built from the vocabulary bundled
+// into the binary on first use. Unknown encodings use cl100k_base.
+func encoder(enc Encoding) *tiktoken.Tiktoken {
+	if enc != EncodingO200K {
+		enc = EncodingCL100K
+	}
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if t, ok := encoders[enc]; ok {
+		return t
+	}
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	t, err := tiktoken.GetEncoding(string(enc))
+	if err != nil {
+		// The vocabularies are embedded, so this only fails on a broken
+		// build.
+		panic(fmt.Sprintf("loading %s: %v", enc, err))
+	}
+	encoders[enc] = t
+	return t
+}
@@ -0,0 +1,115 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		enc  Encoding
+		text string
+		want int
+	}{
+		{EncodingCL100K, "", 0},
+		{EncodingCL100K, "hello world", 2},
+		{EncodingCL100K, "tiktoken is great!", 6},
+		{EncodingO200K, "tiktoken is great!", 6},
+		{EncodingCL100K, "<|endoftext|>", 7},
+	}
+	for _, tt := range tests {
+		if got := CountTokens(tt.enc, tt.text); got != tt.want {
+			t.Errorf("CountTokens(%s, %q) = %d, want %d", tt.enc, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	tests := map[string]Encoding{
+		"gpt-4o-mini":          EncodingO200K,
+		"gpt-5.1":              EncodingO200K,
+		"o3-mini":              EncodingO200K,
+		"openrouter/gpt-4.1":   EncodingO200K,
+		"gpt-4-turbo":          EncodingCL100K,
+		"gpt-3.5-turbo":        EncodingCL100K,
+		"claude-sonnet-4-5":    EncodingCL100K,
+		"llama3.1:8b-instruct": EncodingCL100K,
+	}
+	for model, want := range tests {
+		if got := EncodingForModel(model); got != want {
+			t.Errorf("EncodingForModel(%q) = %s, want %s", model, got, want)
+		}
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"no budget", "one two three", 0, ""},
+		{"negative budget", "one two three", -3, ""},
+		{"fits", "one two three", 3, "one two three"},
+		{"keeps the tail", "one two three four five", 2, " four five"},
+		{"empty", "", 5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TruncateTokens(EncodingCL100K, tt.text, tt.max); got != tt.want {
+				t.Errorf("TruncateTokens(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimContextTokens(t *testing.T) {
+	long := strings.Repeat("word ", 200)
+	chat := []Message{
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: "short answer"},
+		{Role: "user", Content: "next question"},
+	}
+	tests := []struct {
+		name     string
+		messages []Message
+		budget   int
+		// want lists the contents kept; a leading "…" stands for a message
+		// cut down to its tail.
+		want []string
+	}{
+		{"no budget left", chat, 0, []string{"next question"}},
+		{"negative budget", chat, -50, []string{"next question"}},
+		{"latest turn over budget", chat, 5, []string{"next question"}},
+		{"latest turn with an answer over budget", chat[:2], 5, []string{long, "short answer"}},
+		{"older turn too small to cut", chat, 40, []string{"short answer", "next question"}},
+		{"older turn cut to its tail", chat, 60, []string{"…", "short answer", "next question"}},
+		{"everything fits", chat, 1000, []string{long, "short answer", "next question"}},
+		{"empty", nil, 100, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TrimContextTokens(tt.messages, EncodingCL100K, tt.budget)
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept %d messages, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if want == "…" {
+					if !strings.HasPrefix(got[i].Content, "…") || !strings.HasSuffix(long, strings.TrimPrefix(got[i].Content, "…")) {
+						t.Errorf("message %d = %q, want the tail of the original", i, got[i].Content)
+					}
+					continue
+				}
+				if got[i].Content != want {
+					t.Errorf("message %d = %q, want %q", i, got[i].Content, want)
+				}
+			}
+			if len(got) < len(tt.messages) && len(got) > 1 {
+				if used := ContextTokens(EncodingCL100K, "", got); used > tt.budget {
+					t.Errorf("kept %d tokens, over the budget of %d", used, tt.budget)
+				}
+			}
+		})
+	}
+}
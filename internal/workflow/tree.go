@@ -0,0 +1,239 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ChatFormatVersion is the version of the stored chat format:
+//
+//	0: a flat JSON list of messages
+//	1: a ChatTree without a version field
+//	2: a versioned ChatTree whose messages carry metadata
+const ChatFormatVersion = 2
+
+// ChatTree is the stored form of a chat. Every message links to the one it
+// follows, so editing an earlier question starts a new branch instead of
+// discarding what came after it. Active is the last message of the branch
+// being shown and sent as context.
+type ChatTree struct {
+	Version  int       `json:"version"`
+	Messages []Message `json:"messages"`
+	Active   string    `json:"active"`
+}
+
+// Branch summarises one path from the first message to a leaf.
+type Branch struct {
+	Leaf          string
+	Messages      int
+	LastQuestion  string
+	DivergesAfter int
+	Active        bool
+}
+
+func ReadChatTree(path string) (*ChatTree, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &ChatTree{}, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return &ChatTree{}, nil
+	}
+	decoded, err := maybeDecrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeChatTree(decoded)
+}
+
+func WriteChatTree(path string, tree *ChatTree) error {
+	tree.Version = ChatFormatVersion
+	if tree.Messages == nil {
+		tree.Messages = []Message{}
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	payload, err := maybeEncrypt(data)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(path, payload)
+}
+
+// decodeChatTree reads any version of the chat format and migrates it to
+// the current one. Files are rewritten in the current format on next save.
+func decodeChatTree(data []byte) (*ChatTree, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return &ChatTree{Version: ChatFormatVersion}, nil
+	}
+	tree := &ChatTree{}
+	if trimmed[0] == '[' {
+		var flat []Message
+		if err := json.Unmarshal(trimmed, &flat); err != nil {
+			return nil, err
+		}
+		tree.SetBranch(flat)
+	} else if err := json.Unmarshal(trimmed, tree); err != nil {
+		return nil, err
+	}
+	if err := migrateChatTree(tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// migrateChatTree upgrades a decoded tree in place. Version 0 files were
+// already linked into a tree while decoding, and neither 0 nor 1 recorded
+// metadata, so their messages simply keep those fields empty.
+func migrateChatTree(tree *ChatTree) error {
+	if tree.Version > ChatFormatVersion {
+		return fmt.Errorf("chat was saved in format version %d; update the workflow to read it", tree.Version)
+	}
+	tree.Version = ChatFormatVersion
+	return nil
+}
+
+// Branch returns the messages from the first one to Active, in order.
+func (t *ChatTree) Branch() []Message {
+	return t.path(t.Active)
+}
+
+// SetBranch makes msgs the active branch. Messages carrying the ID of a stored
+// message update it in place; the others are added as its descendants.
+func (t *ChatTree) SetBranch(msgs []Message) {
+	parent := ""
+	for _, m := range msgs {
+		if i := t.index(m.ID); i >= 0 {
+			m.Parent = t.Messages[i].Parent
+			t.Messages[i] = m
+		} else {
+			m.ID = t.nextID()
+			m.Parent = parent
+			t.Messages = append(t.Messages, m)
+		}
+		parent = m.ID
+	}
+	t.Active = parent
+}
+
+// Edit adds content as a sibling of the user message id and makes the new
+// branch, which ends at that question, active.
+func (t *ChatTree) Edit(id, content string) error {
+	i := t.index(id)
+	if i < 0 {
+		return fmt.Errorf("message %q not found", id)
+	}
+	if t.Messages[i].Role != "user" {
+		return errors.New("only questions can be edited")
+	}
+	edited := Message{
+		ID:      t.nextID(),
+		Parent:  t.Messages[i].Parent,
+		Role:    "user",
+		Content: content,
+		Created: time.Now().Unix(),
+	}
+	t.Messages = append(t.Messages, edited)
+	t.Active = edited.ID
+	return nil
+}
+
+// Switch activates the branch ending at leaf. Any other message selects the
+// newest branch passing through it.
+func (t *ChatTree) Switch(id string) error {
+	if t.index(id) < 0 {
+		return fmt.Errorf("message %q not found", id)
+	}
+	children := t.children()
+	for len(children[id]) > 0 {
+		kids := children[id]
+		id = kids[len(kids)-1]
+	}
+	t.Active = id
+	return nil
+}
+
+// Branches lists every leaf, oldest first.
+func (t *ChatTree) Branches() []Branch {
+	children := t.children()
+	active := t.Branch()
+	var branches []Branch
+	for _, m := range t.Messages {
+		if len(children[m.ID]) > 0 {
+			continue
+		}
+		path := t.path(m.ID)
+		branch := Branch{Leaf: m.ID, Messages: len(path), Active: m.ID == t.Active}
+		for _, p := range path {
+			if p.Role == "user" {
+				branch.LastQuestion = p.Content
+			}
+		}
+		for branch.DivergesAfter < len(path) && branch.DivergesAfter < len(active) && path[branch.DivergesAfter].ID == active[branch.DivergesAfter].ID {
+			branch.DivergesAfter++
+		}
+		branches = append(branches, branch)
+	}
+	return branches
+}
+
+func (t *ChatTree) path(id string) []Message {
+	var path []Message
+	seen := map[string]bool{}
+	for id != "" && !seen[id] {
+		seen[id] = true
+		i := t.index(id)
+		if i < 0 {
+			break
+		}
+		path = append(path, t.Messages[i])
+		id = t.Messages[i].Parent
+	}
+	for l, r := 0, len(path)-1; l < r; l, r = l+1, r-1 {
+		path[l], path[r] = path[r], path[l]
+	}
+	if path == nil {
+		return []Message{}
+	}
+	return path
+}
+
+func (t *ChatTree) children() map[string][]string {
+	children := map[string][]string{}
+	for _, m := range t.Messages {
+		children[m.Parent] = append(children[m.Parent], m.ID)
+	}
+	return children
+}
+
+func (t *ChatTree) index(id string) int {
+	if id == "" {
+		return -1
+	}
+	for i, m := range t.Messages {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *ChatTree) nextID() string {
+	for n := len(t.Messages) + 1; ; n++ {
+		id := fmt.Sprintf("m%d", n)
+		if t.index(id) < 0 {
+			return id
+		}
+	}
+}
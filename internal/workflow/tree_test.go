@@ -0,0 +1,140 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeFlatChat(t *testing.T) {
+	flat := []byte(`[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]`)
+	tree, err := decodeChatTree(flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Version != ChatFormatVersion {
+		t.Errorf("version = %d, want %d", tree.Version, ChatFormatVersion)
+	}
+	branch := tree.Branch()
+	if len(branch) != 3 || len(tree.Branches()) != 1 {
+		t.Fatalf("branch = %+v, want the three messages as the only branch", branch)
+	}
+	parent := ""
+	for i, m := range branch {
+		if m.ID == "" || m.Parent != parent {
+			t.Errorf("message %d = %+v, want it linked to %q", i, m, parent)
+		}
+		parent = m.ID
+	}
+
+	// The file is not rewritten until the next save, so every read must
+	// hand out the same IDs for an edit to find the question it picked.
+	again, err := decodeChatTree(flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range again.Branch() {
+		if m.ID != branch[i].ID {
+			t.Errorf("message %d read as %s, then %s", i, branch[i].ID, m.ID)
+		}
+	}
+	if err := again.Edit(branch[2].ID, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Branch(); len(got) != 3 || got[2].Content != "c2" || got[1].ID != branch[1].ID {
+		t.Errorf("edited branch = %+v", got)
+	}
+}
+
+func TestMigrateChatTree(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr string
+	}{
+		{"empty", "  ", nil, ""},
+		{"flat", `[{"role":"user","content":"a"}]`, []string{"a"}, ""},
+		{
+			"version 1 without a version field",
+			`{"messages":[{"id":"m1","role":"user","content":"a"},{"id":"m2","parent":"m1","role":"assistant","content":"b"},{"id":"m3","parent":"m1","role":"assistant","content":"other"}],"active":"m2"}`,
+			[]string{"a", "b"},
+			"",
+		},
+		{
+			"current version with metadata",
+			`{"version":2,"messages":[{"id":"m1","role":"user","content":"a","created":1700000000},{"id":"m2","parent":"m1","role":"assistant","content":"b","model":"gpt-4o","prompt_tokens":3,"completion_tokens":1,"finish_reason":"stop"}],"active":"m2"}`,
+			[]string{"a", "b"},
+			"",
+		},
+		{"newer version", `{"version":3,"messages":[],"active":""}`, nil, "format version 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := decodeChatTree([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tree.Version != ChatFormatVersion {
+				t.Errorf("version = %d, want %d", tree.Version, ChatFormatVersion)
+			}
+			branch := tree.Branch()
+			if len(branch) != len(tt.want) {
+				t.Fatalf("branch = %+v, want %q", branch, tt.want)
+			}
+			for i, want := range tt.want {
+				if branch[i].Content != want {
+					t.Errorf("message %d = %q, want %q", i, branch[i].Content, want)
+				}
+			}
+		})
+	}
+
+	tree, err := decodeChatTree([]byte(tests[3].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer := tree.Branch()[1]; answer.Model != "gpt-4o" || answer.PromptTokens != 3 || answer.FinishReason != "stop" {
+		t.Errorf("answer = %+v, want its metadata kept", answer)
+	}
+}
+
+func TestBranchesDivergence(t *testing.T) {
+	tree := &ChatTree{}
+	tree.SetBranch([]Message{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}})
+	first := tree.Branch()
+	if err := tree.Edit(first[2].ID, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Edit(first[0].ID, "a2"); err != nil {
+		t.Fatal(err)
+	}
+
+	branches := tree.Branches()
+	if len(branches) != 3 {
+		t.Fatalf("branches = %+v, want three", branches)
+	}
+	// Seen from the branch that rewrote the first question, neither other
+	// branch shares a message with it.
+	for _, b := range branches[:2] {
+		if b.Active || b.DivergesAfter != 0 {
+			t.Errorf("branch = %+v, want it to share nothing with the active one", b)
+		}
+	}
+	if b := branches[2]; !b.Active || b.Messages != 1 || b.LastQuestion != "a2" {
+		t.Errorf("active branch = %+v", b)
+	}
+
+	if err := tree.Switch(first[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	branches = tree.Branches()
+	if b := branches[0]; b.Leaf != first[2].ID || b.DivergesAfter != 2 {
+		t.Errorf("branch = %+v, want it to branch off after message 2", b)
+	}
+}
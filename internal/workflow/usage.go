@@ -0,0 +1,273 @@
+package workflow
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UsageChat  = "chat"
+	UsageImage = "image"
+)
+
+// UsageRecord is one billed request in the ledger. Estimated is set when the
+// endpoint reported no usage and the tokens were counted locally.
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Kind             string    `json:"kind"`
+	Model            string    `json:"model"`
+	PromptTokens     int64     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int64     `json:"completion_tokens,omitempty"`
+	Images           int       `json:"images,omitempty"`
+	Size             string    `json:"size,omitempty"`
+	Quality          string    `json:"quality,omitempty"`
+	Estimated        bool      `json:"estimated,omitempty"`
+}
+
+type UsagePeriod string
+
+const (
+	UsageDaily   UsagePeriod = "daily"
+	UsageWeekly  UsagePeriod = "weekly"
+	UsageMonthly UsagePeriod = "monthly"
+)
+
+// How many periods a usage report goes back.
+var usageWindow = map[UsagePeriod]int{
+	UsageDaily:   14,
+	UsageWeekly:  8,
+	UsageMonthly: 12,
+}
+
+func UsagePath(dataDir string) string {
+	return filepath.Join(dataDir, "usage.jsonl")
+}
+
+func ParseUsagePeriod(value string) (UsagePeriod, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "day", "daily":
+		return UsageDaily, nil
+	case "week", "weekly":
+		return UsageWeekly, nil
+	case "month", "monthly":
+		return UsageMonthly, nil
+	}
+	return "", fmt.Errorf("unknown usage period %q", value)
+}
+
+// AppendUsage adds rec to the ledger. The ledger is only ever appended to;
+// each line is encrypted on its own when storage_secret is set.
+func AppendUsage(path string, rec UsageRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line, err := maybeEncrypt(data)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadUsage returns every record in the ledger, skipping lines that cannot be
+// read.
+func ReadUsage(path string) ([]UsageRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []UsageRecord
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		decoded, err := maybeDecrypt(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping usage line %d: %v\n", n, err)
+			continue
+		}
+		var rec UsageRecord
+		if err := json.Unmarshal(decoded, &rec); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping usage line %d: %v\n", n, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+type usageRow struct {
+	model      string
+	requests   int
+	prompt     int64
+	completion int64
+	images     int
+	cost       float64
+	unpriced   bool
+	estimated  bool
+}
+
+// MarkdownUsage renders spend per model for each period in the report window,
+// newest first.
+func MarkdownUsage(records []UsageRecord, prices Prices, period UsagePeriod, now time.Time) string {
+	window := usageWindow[period]
+	oldest := usagePeriodStart(now, period)
+	for i := 1; i < window; i++ {
+		oldest = usagePeriodStart(oldest.Add(-time.Hour), period)
+	}
+
+	buckets := map[time.Time]map[string]*usageRow{}
+	total, unpriced := 0.0, false
+	for _, rec := range records {
+		t := rec.Time.In(now.Location())
+		if t.Before(oldest) {
+			continue
+		}
+		start := usagePeriodStart(t, period)
+		if buckets[start] == nil {
+			buckets[start] = map[string]*usageRow{}
+		}
+		row := buckets[start][rec.Model]
+		if row == nil {
+			row = &usageRow{model: rec.Model}
+			buckets[start][rec.Model] = row
+		}
+		cost, ok := prices.Cost(rec)
+		row.requests++
+		row.prompt += rec.PromptTokens
+		row.completion += rec.CompletionTokens
+		row.images += rec.Images
+		row.cost += cost
+		row.unpriced = row.unpriced || !ok
+		row.estimated = row.estimated || rec.Estimated
+		total += cost
+		unpriced = unpriced || !ok
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("# Usage · %s\n\n", usagePeriodTitle(period, window)))
+	if len(buckets) == 0 {
+		builder.WriteString("Nothing recorded in this period.")
+		return builder.String()
+	}
+	builder.WriteString(fmt.Sprintf("**%s** in total", formatCost(total)))
+	if unpriced {
+		builder.WriteString(". Models marked ? have no price; add them with `usage_prices`")
+	}
+	builder.WriteString(".\n\n")
+
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].After(starts[j]) })
+
+	for _, start := range starts {
+		rows := make([]*usageRow, 0, len(buckets[start]))
+		subtotal := 0.0
+		for _, row := range buckets[start] {
+			rows = append(rows, row)
+			subtotal += row.cost
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].cost != rows[j].cost {
+				return rows[i].cost > rows[j].cost
+			}
+			return rows[i].model < rows[j].model
+		})
+
+		builder.WriteString(fmt.Sprintf("## %s · %s\n\n", usagePeriodLabel(start, period), formatCost(subtotal)))
+		builder.WriteString("| Model | Requests | Input tokens | Output tokens | Images | Cost |\n")
+		builder.WriteString("|---|--:|--:|--:|--:|--:|\n")
+		for _, row := range rows {
+			cost := formatCost(row.cost)
+			if row.estimated {
+				cost = "≈" + cost
+			}
+			if row.unpriced {
+				if row.cost == 0 {
+					cost = "?"
+				} else {
+					cost += " ?"
+				}
+			}
+			builder.WriteString(fmt.Sprintf("| %s | %d | %s | %s | %s | %s |\n",
+				row.model, row.requests, formatCount(row.prompt), formatCount(row.completion), formatCount(int64(row.images)), cost))
+		}
+		builder.WriteString("\n")
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+func usagePeriodStart(t time.Time, period UsagePeriod) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case UsageWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case UsageMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return day
+}
+
+func usagePeriodLabel(start time.Time, period UsagePeriod) string {
+	switch period {
+	case UsageWeekly:
+		return "Week of " + start.Format("Jan 2, 2006")
+	case UsageMonthly:
+		return start.Format("January 2006")
+	}
+	return start.Format("Mon, Jan 2, 2006")
+}
+
+func usagePeriodTitle(period UsagePeriod, window int) string {
+	switch period {
+	case UsageWeekly:
+		return fmt.Sprintf("Last %d weeks", window)
+	case UsageMonthly:
+		return fmt.Sprintf("Last %d months", window)
+	}
+	return fmt.Sprintf("Last %d days", window)
+}
+
+func formatCost(cost float64) string {
+	if cost > 0 && cost < 0.01 {
+		return "<$0.01"
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+func formatCount(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	s := strconv.FormatInt(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrFirstTokenTimeout = errors.New("no answer arrived before the first-token timeout")
+	ErrIdleTimeout       = errors.New("the answer stopped arriving for longer than the idle timeout")
+)
+
+// Watchdog cancels a streamed request that waits too long for its first chunk
+// or goes quiet between chunks.
+type Watchdog struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	idle    time.Duration
+	started atomic.Bool
+}
+
+// NewWatchdog returns a context for the request that the watchdog cancels,
+// with the first-token timeout already running. A zero timeout never fires.
+func NewWatchdog(parent context.Context, firstToken, idle time.Duration) (context.Context, *Watchdog) {
+	w := &Watchdog{idle: idle}
+	w.ctx, w.cancel = context.WithCancelCause(parent)
+	w.timer = time.AfterFunc(firstToken, func() {
+		if w.started.Load() {
+			w.cancel(ErrIdleTimeout)
+		} else {
+			w.cancel(ErrFirstTokenTimeout)
+		}
+	})
+	if firstToken <= 0 {
+		w.timer.Stop()
+	}
+	return w.ctx, w
+}
+
+// Kick records that a chunk arrived and restarts the idle timeout.
+func (w *Watchdog) Kick() {
+	w.started.Store(true)
+	w.timer.Stop()
+	if w.idle > 0 {
+		w.timer.Reset(w.idle)
+	}
+}
+
+// Extend restarts the idle timeout for a chunk that shows progress without
+// being part of the answer, such as reasoning. Before the answer has started
+// the first-token timeout keeps running instead.
+func (w *Watchdog) Extend() {
+	if w.started.Load() {
+		w.Kick()
+	}
+}
+
+// TimedOut returns the timeout that cancelled the request, if one did.
+func (w *Watchdog) TimedOut() error {
+	cause := context.Cause(w.ctx)
+	if errors.Is(cause, ErrFirstTokenTimeout) || errors.Is(cause, ErrIdleTimeout) {
+		return cause
+	}
+	return nil
+}
+
+// Stop disarms the watchdog and releases its context once the request is
+// done with.
+func (w *Watchdog) Stop() {
+	w.timer.Stop()
+	w.cancel(nil)
+}